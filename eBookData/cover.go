@@ -0,0 +1,71 @@
+package eBookData
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"strings"
+)
+
+// CoverSource tells where the cover image was found in the book
+type CoverSource string
+
+const (
+	// EPUB3 manifest item with properties="cover-image"
+	COVER_SOURCE_PROPERTIES CoverSource = "cover-image"
+	// EPUB2 <meta name="cover"> pointing to a manifest item
+	COVER_SOURCE_META CoverSource = "meta"
+	// EPUB2 <guide><reference type="cover">
+	COVER_SOURCE_GUIDE CoverSource = "guide"
+	// EPUB3 landmarks of the navigation document
+	COVER_SOURCE_LANDMARKS CoverSource = "landmarks"
+	// image of an XHTML page named as cover
+	COVER_SOURCE_COVER_PAGE CoverSource = "cover-page"
+	// first image in the reading order
+	COVER_SOURCE_SPINE CoverSource = "spine"
+	// mobipocket EXTH 201 cover offset
+	COVER_SOURCE_EXTH_COVER CoverSource = "exth-201"
+	// mobipocket EXTH 202 thumbnail offset
+	COVER_SOURCE_EXTH_THUMBNAIL CoverSource = "exth-202"
+	// mobipocket first image record, when there is no cover offset
+	COVER_SOURCE_FIRST_IMAGE CoverSource = "first-image"
+)
+
+// Cover is the cover image of a book
+type Cover struct {
+	Data []byte
+	// MediaType is the media type declared in the book, or sniffed from the data, eg. "image/jpeg"
+	MediaType string
+	// Width and Height are the pixel dimensions, 0 if the image can't be decoded (eg. SVG)
+	Width  int
+	Height int
+	Source CoverSource
+	// Path is the name of the cover file in the container, empty for record based formats
+	Path string
+	// Record is the PalmDB record of the cover, -1 for container based formats
+	Record int
+}
+
+// NewCover creates a cover from the image data. If mediaType is empty, it is sniffed from the data.
+func NewCover(data []byte, mediaType string, source CoverSource) *Cover {
+	cover := &Cover{Data: data, MediaType: mediaType, Source: source, Record: -1}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil {
+		cover.Width = config.Width
+		cover.Height = config.Height
+	}
+	if cover.MediaType == "" {
+		if err == nil {
+			cover.MediaType = "image/" + format
+		} else {
+			cover.MediaType = http.DetectContentType(data)
+		}
+	}
+	if i := strings.Index(cover.MediaType, ";"); i >= 0 {
+		cover.MediaType = strings.TrimSpace(cover.MediaType[:i])
+	}
+	return cover
+}
@@ -0,0 +1,23 @@
+package eBookData
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestNewCover(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 30, 40))); err != nil {
+		t.Fatalf("Failed to encode png: %v", err)
+	}
+	cover := NewCover(buf.Bytes(), "", COVER_SOURCE_EXTH_COVER)
+	if cover.MediaType != "image/png" || cover.Width != 30 || cover.Height != 40 || cover.Record != -1 {
+		t.Errorf("Unexpected cover %s %dx%d record %d", cover.MediaType, cover.Width, cover.Height, cover.Record)
+	}
+	cover = NewCover([]byte("<svg/>"), "image/svg+xml", COVER_SOURCE_META)
+	if cover.MediaType != "image/svg+xml" || cover.Width != 0 {
+		t.Errorf("Unexpected svg cover %s %dx%d", cover.MediaType, cover.Width, cover.Height)
+	}
+}
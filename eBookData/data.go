@@ -1,15 +1,51 @@
 package eBookData
 
+// Collection is a group of books, eg. a series, Position is the place of the book in it
+type Collection struct {
+	Name     string
+	Type     string
+	Position float64
+}
+
 type Metadata interface {
+	// Author returns the author names in the style of the ParseOptions
 	Author() []string
+	Authors() []Person
 	Title() string
+	Subtitle() string
 	Publisher() string
+	// PubDate returns the year of the publication
 	PubDate() string
+	PublishedDate() Date
+	// ISBN returns the ISBN-13 form if the ISBN is valid, see ParseOptions.KeepRawISBN
 	ISBN() string
 	Contributor() []string
+	Contributors() []Person
+	Language() string
+	Description() string
+	Subjects() []string
+	Series() string
+	SeriesIndex() float64
+	// Collections are the EPUB3 collections (series, sets) the book belongs to
+	Collections() []Collection
+	Rights() string
+	// Identifiers are the identifiers of the book in the order of the book, see FindIdentifier
+	Identifiers() []Identifier
+	Modified() Date
+	// SortTitle is the title used for sorting, eg. "Hobbit, The"
+	SortTitle() string
+	// Rating is the rating of the book in stars, 0-5
+	Rating() float64
+	// Added is the date the book was added to the library
+	Added() Date
+	// Custom returns the custom fields of the library (eg. calibre custom columns) by their label
+	Custom() map[string]any
+	// UserCategories returns the user defined categories with the names of their items
+	UserCategories() map[string][]string
 }
 
 type Book interface {
 	Metadata() Metadata
-	Cover() []byte
+	// Cover returns nil if the book has no cover, or it was not loaded
+	Cover() *Cover
 }
@@ -0,0 +1,125 @@
+package eBookData
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DatePrecision is the part of a Date that is known
+type DatePrecision int
+
+const (
+	DATE_PRECISION_NONE DatePrecision = iota
+	DATE_PRECISION_YEAR
+	DATE_PRECISION_MONTH
+	DATE_PRECISION_DAY
+	DATE_PRECISION_FULL
+)
+
+// Date is a parsed date of the metadata, Time is only valid up to the Precision
+type Date struct {
+	Time      time.Time
+	Precision DatePrecision
+}
+
+type dateFormat struct {
+	layout    string
+	precision DatePrecision
+}
+
+// the formats tried in order, ISO 8601 first, then the ones seen in EXTH records
+var dateFormats = []dateFormat{
+	{time.RFC3339Nano, DATE_PRECISION_FULL},
+	{"2006-01-02T15:04:05", DATE_PRECISION_FULL},
+	{"2006-01-02T15:04Z07:00", DATE_PRECISION_FULL},
+	{"2006-01-02T15:04", DATE_PRECISION_FULL},
+	{"2006-01-02 15:04:05Z07:00", DATE_PRECISION_FULL},
+	{"2006-01-02 15:04:05", DATE_PRECISION_FULL},
+	{"2006-01-02 15:04", DATE_PRECISION_FULL},
+	{time.RFC1123Z, DATE_PRECISION_FULL},
+	{time.RFC1123, DATE_PRECISION_FULL},
+	{"2006-01-02", DATE_PRECISION_DAY},
+	{"20060102", DATE_PRECISION_DAY},
+	{"2006/01/02", DATE_PRECISION_DAY},
+	{"2006.01.02", DATE_PRECISION_DAY},
+	{"2006. 01. 02.", DATE_PRECISION_DAY},
+	{"01/02/2006", DATE_PRECISION_DAY},
+	{"02.01.2006", DATE_PRECISION_DAY},
+	{"January 2, 2006", DATE_PRECISION_DAY},
+	{"Jan 2, 2006", DATE_PRECISION_DAY},
+	{"2 January 2006", DATE_PRECISION_DAY},
+	{"2 Jan 2006", DATE_PRECISION_DAY},
+	{"2006-01", DATE_PRECISION_MONTH},
+	{"2006/01", DATE_PRECISION_MONTH},
+	{"January 2006", DATE_PRECISION_MONTH},
+	{"2006", DATE_PRECISION_YEAR},
+}
+
+var leadingYear = regexp.MustCompile(`^(\d{4})\D`)
+
+// calibre writes year 101 for the unknown dates
+const UNDEFINED_YEAR = 101
+
+// ParseDate parses the date formats used by the ebook formats.
+// If only the leading year is recognizable, a year precision date is returned.
+func ParseDate(value string) (Date, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Date{}, nil
+	}
+	for _, format := range dateFormats {
+		t, err := time.Parse(format.layout, value)
+		if err != nil {
+			continue
+		}
+		if t.Year() <= UNDEFINED_YEAR {
+			return Date{}, nil
+		}
+		return Date{Time: t, Precision: format.precision}, nil
+	}
+	if match := leadingYear.FindStringSubmatch(value); match != nil {
+		year, _ := strconv.Atoi(match[1])
+		if year > UNDEFINED_YEAR {
+			return Date{Time: time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC), Precision: DATE_PRECISION_YEAR}, nil
+		}
+	}
+	return Date{}, fmt.Errorf("unknown date format: %s", value)
+}
+
+func (d Date) IsZero() bool {
+	return d.Precision == DATE_PRECISION_NONE
+}
+
+// Year returns the year, or 0 if the date is not known
+func (d Date) Year() int {
+	if d.IsZero() {
+		return 0
+	}
+	return d.Time.Year()
+}
+
+// String formats the date as ISO 8601 up to its precision
+func (d Date) String() string {
+	switch d.Precision {
+	case DATE_PRECISION_YEAR:
+		return d.Time.Format("2006")
+	case DATE_PRECISION_MONTH:
+		return d.Time.Format("2006-01")
+	case DATE_PRECISION_DAY:
+		return d.Time.Format("2006-01-02")
+	case DATE_PRECISION_FULL:
+		return d.Time.Format(time.RFC3339)
+	}
+	return ""
+}
+
+// YearString returns the year as a string, or empty if the date is not known
+func (d Date) YearString() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Time.Format("2006")
+}
@@ -0,0 +1,37 @@
+package eBookData
+
+import "testing"
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		value     string
+		precision DatePrecision
+		expected  string
+	}{
+		{"2022", DATE_PRECISION_YEAR, "2022"},
+		{"2022-07", DATE_PRECISION_MONTH, "2022-07"},
+		{"2022-07-15", DATE_PRECISION_DAY, "2022-07-15"},
+		{"2022-07-15T10:20:30Z", DATE_PRECISION_FULL, "2022-07-15T10:20:30Z"},
+		{"2022-07-15T10:20:30+02:00", DATE_PRECISION_FULL, "2022-07-15T10:20:30+02:00"},
+		{"2022-07-15T10:20:30.123456+00:00", DATE_PRECISION_FULL, "2022-07-15T10:20:30Z"},
+		{"07/15/2022", DATE_PRECISION_DAY, "2022-07-15"},
+		{"July 15, 2022", DATE_PRECISION_DAY, "2022-07-15"},
+		{"Fri, 15 Jul 2022 10:20:30 +0000", DATE_PRECISION_FULL, "2022-07-15T10:20:30Z"},
+		{"2022-13-45 garbage", DATE_PRECISION_YEAR, "2022"},
+		{"0101-01-01T00:00:00+00:00", DATE_PRECISION_NONE, ""},
+		{"", DATE_PRECISION_NONE, ""},
+	}
+	for _, test := range tests {
+		date, err := ParseDate(test.value)
+		if err != nil {
+			t.Errorf("ParseDate(%q) failed: %v", test.value, err)
+			continue
+		}
+		if date.Precision != test.precision || date.String() != test.expected {
+			t.Errorf("ParseDate(%q) = %q (precision %d), expected %q (precision %d)", test.value, date.String(), date.Precision, test.expected, test.precision)
+		}
+	}
+	if _, err := ParseDate("sometime"); err == nil {
+		t.Errorf("Expected error for unknown date format")
+	}
+}
@@ -0,0 +1,57 @@
+package eBookData
+
+// EncryptionScheme tells how the book is protected
+type EncryptionScheme string
+
+const (
+	// the book is not encrypted
+	ENCRYPTION_NONE EncryptionScheme = ""
+	// only the fonts are obfuscated (IDPF or Adobe font mangling), the book is readable
+	ENCRYPTION_FONT_OBFUSCATION EncryptionScheme = "font-obfuscation"
+	// Adobe Digital Editions DRM
+	ENCRYPTION_ADOBE_ADEPT EncryptionScheme = "adobe-adept"
+	// Readium LCP DRM
+	ENCRYPTION_READIUM_LCP EncryptionScheme = "readium-lcp"
+	// Apple Books FairPlay DRM
+	ENCRYPTION_APPLE_FAIRPLAY EncryptionScheme = "apple-fairplay"
+	// Barnes & Noble DRM, the Adobe scheme with a key made from the name and the credit card of the buyer
+	ENCRYPTION_BARNES_NOBLE EncryptionScheme = "barnes-noble"
+	// encrypted resources without a known DRM
+	ENCRYPTION_UNKNOWN EncryptionScheme = "unknown"
+)
+
+// EncryptedResource is an encrypted file of the book
+type EncryptedResource struct {
+	// Path is the name of the file in the container
+	Path string
+	// Algorithm is the URI of the encryption algorithm
+	Algorithm string
+	// Obfuscated is true for the obfuscated fonts, that can be read without a key
+	Obfuscated bool
+}
+
+// Encryption is the protection of a book
+type Encryption struct {
+	Scheme    EncryptionScheme
+	Resources []EncryptedResource
+}
+
+// IsDRM reports whether the book is protected by DRM, so its content can't be read without the key of the buyer
+func (e Encryption) IsDRM() bool {
+	return e.Scheme != ENCRYPTION_NONE && e.Scheme != ENCRYPTION_FONT_OBFUSCATION
+}
+
+// IsEncrypted reports whether the file is encrypted with a key, obfuscated fonts are not reported
+func (e Encryption) IsEncrypted(path string) bool {
+	for _, resource := range e.Resources {
+		if resource.Path == path && !resource.Obfuscated {
+			return true
+		}
+	}
+	return false
+}
+
+// Encrypted is implemented by the books that can tell their protection
+type Encrypted interface {
+	Encryption() Encryption
+}
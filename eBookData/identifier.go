@@ -0,0 +1,148 @@
+package eBookData
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/ignisVeneficus/ebook/isbn"
+)
+
+// the identifier schemes with normalized names
+const (
+	SCHEME_ISBN    = "ISBN"
+	SCHEME_UUID    = "UUID"
+	SCHEME_ASIN    = "ASIN"
+	SCHEME_DOI     = "DOI"
+	SCHEME_GOOGLE  = "GOOGLE"
+	SCHEME_CALIBRE = "CALIBRE"
+	SCHEME_URI     = "URI"
+)
+
+// Identifier is an identifier of the book, eg. an ISBN
+type Identifier struct {
+	// Scheme is the normalized scheme, eg. SCHEME_ISBN, empty if the scheme is unknown
+	Scheme string
+	// Value is the identifier without the prefix of the scheme, eg. "9780306406157" for "urn:isbn:9780306406157"
+	Value string
+}
+
+// AppendIdentifier appends the identifier to the list, unless the value is empty or the list already has it
+func AppendIdentifier(identifiers []Identifier, scheme string, value string) []Identifier {
+	identifier := Identifier{Scheme: scheme, Value: strings.TrimSpace(value)}
+	if identifier.Value == "" || slices.Contains(identifiers, identifier) {
+		return identifiers
+	}
+	return append(identifiers, identifier)
+}
+
+// FindIdentifier returns the value of the first identifier with the scheme, or an empty string
+func FindIdentifier(identifiers []Identifier, scheme string) string {
+	for _, identifier := range identifiers {
+		if identifier.Scheme == scheme {
+			return identifier.Value
+		}
+	}
+	return ""
+}
+
+var schemeAliases = map[string]string{
+	"ISBN":      SCHEME_ISBN,
+	"ISBN-10":   SCHEME_ISBN,
+	"ISBN-13":   SCHEME_ISBN,
+	"UUID":      SCHEME_UUID,
+	"UUID_ID":   SCHEME_UUID,
+	"ASIN":      SCHEME_ASIN,
+	"AMAZON":    SCHEME_ASIN,
+	"MOBI-ASIN": SCHEME_ASIN,
+	"DOI":       SCHEME_DOI,
+	"GOOGLE":    SCHEME_GOOGLE,
+	"CALIBRE":   SCHEME_CALIBRE,
+	"URI":       SCHEME_URI,
+	"URL":       SCHEME_URI,
+	// ONIX codelist 5, used by EPUB3 identifier-type refinements
+	"02": SCHEME_ISBN,
+	"15": SCHEME_ISBN,
+	"06": SCHEME_DOI,
+	"22": SCHEME_URI,
+}
+
+// NormalizeScheme returns the normalized name of a known scheme, or the upper case name of an unknown one
+func NormalizeScheme(scheme string) string {
+	scheme = strings.ToUpper(strings.TrimSpace(scheme))
+	if normalized, ok := schemeAliases[scheme]; ok {
+		return normalized
+	}
+	return scheme
+}
+
+// IsKnownScheme reports whether the scheme is one of the normalized schemes
+func IsKnownScheme(scheme string) bool {
+	_, ok := schemeAliases[strings.ToUpper(strings.TrimSpace(scheme))]
+	return ok
+}
+
+var identifierPrefixes = []struct {
+	prefix string
+	scheme string
+}{
+	{"urn:isbn:", SCHEME_ISBN},
+	{"isbn:", SCHEME_ISBN},
+	{"urn:uuid:", SCHEME_UUID},
+	{"uuid:", SCHEME_UUID},
+	{"urn:doi:", SCHEME_DOI},
+	{"doi:", SCHEME_DOI},
+	{"https://doi.org/", SCHEME_DOI},
+	{"http://dx.doi.org/", SCHEME_DOI},
+	{"urn:asin:", SCHEME_ASIN},
+	{"asin:", SCHEME_ASIN},
+	{"amazon:", SCHEME_ASIN},
+	{"mobi-asin:", SCHEME_ASIN},
+	{"google:", SCHEME_GOOGLE},
+	{"calibre:", SCHEME_CALIBRE},
+}
+
+// ParseIdentifier detects the scheme from the prefix of the value (eg. "urn:isbn:", "doi:", "google:")
+// and returns the scheme with the value without the prefix. The scheme is empty if there is no known prefix.
+func ParseIdentifier(value string) (string, string) {
+	value = strings.TrimSpace(value)
+	lower := strings.ToLower(value)
+	for _, p := range identifierPrefixes {
+		if strings.HasPrefix(lower, p.prefix) {
+			return p.scheme, strings.TrimSpace(value[len(p.prefix):])
+		}
+	}
+	return "", value
+}
+
+// isUuid reports whether the value is a UUID in the 8-4-4-4-12 hexadecimal form
+func isUuid(value string) bool {
+	if len(value) != 36 {
+		return false
+	}
+	for i, c := range value {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if c != '-' {
+				return false
+			}
+		case !strings.ContainsRune("0123456789abcdefABCDEF", c):
+			return false
+		}
+	}
+	return true
+}
+
+// DetectScheme is ParseIdentifier that also recognizes the values without prefix: a valid ISBN or a UUID.
+// The scheme is empty if the value does not tell it.
+func DetectScheme(value string) (string, string) {
+	scheme, stripped := ParseIdentifier(value)
+	switch {
+	case scheme != "":
+		return scheme, stripped
+	case isbn.IsValid(stripped):
+		return SCHEME_ISBN, stripped
+	case isUuid(stripped):
+		return SCHEME_UUID, stripped
+	}
+	return "", stripped
+}
@@ -0,0 +1,97 @@
+package eBookData
+
+import (
+	"github.com/ignisVeneficus/ebook/isbn"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// AuthorNameStyle selects which form of a person's name the string accessors return
+type AuthorNameStyle string
+
+const (
+	// AUTHOR_NAME_DISPLAY returns the name as written in the book, eg. "Jane Smith"
+	AUTHOR_NAME_DISPLAY AuthorNameStyle = "display"
+	// AUTHOR_NAME_FILE_AS returns the sort name when the book has one, eg. "Smith, Jane"
+	AUTHOR_NAME_FILE_AS AuthorNameStyle = "file-as"
+)
+
+// ParseOptions controls how the readers of every format parse a book.
+// The zero value (and a nil pointer) is a valid, lenient configuration.
+type ParseOptions struct {
+	// AuthorName is the form of the author names, AUTHOR_NAME_DISPLAY if empty
+	AuthorName AuthorNameStyle
+	// SkipCover disables loading the cover image
+	SkipCover bool
+	// MaxFileSize is the maximum size of the book in bytes, 0 means no limit
+	MaxFileSize int64
+	// MaxCoverSize is the maximum size of the cover image in bytes, 0 means no limit
+	MaxCoverSize int64
+	// KeepRawISBN returns the ISBN as written in the book, instead of the normalized ISBN-13 form
+	KeepRawISBN bool
+	// Strict turns recoverable problems (missing cover file, oversized cover, broken metadata records) into errors
+	Strict bool
+	// SelectRendition chooses the rendition of multiple rendition books by its index, the first one is used if nil
+	SelectRendition func(renditions []Rendition) int
+	// Logger receives the messages of the readers, the global zerolog logger is used if nil
+	Logger *zerolog.Logger
+}
+
+func (o *ParseOptions) GetAuthorName() AuthorNameStyle {
+	if o == nil || o.AuthorName == "" {
+		return AUTHOR_NAME_DISPLAY
+	}
+	return o.AuthorName
+}
+
+func (o *ParseOptions) LoadCover() bool {
+	return o == nil || !o.SkipCover
+}
+
+func (o *ParseOptions) IsStrict() bool {
+	return o != nil && o.Strict
+}
+
+// FileSizeAllowed reports whether a book of the given size fits into MaxFileSize
+func (o *ParseOptions) FileSizeAllowed(size int64) bool {
+	return o == nil || o.MaxFileSize <= 0 || size <= o.MaxFileSize
+}
+
+// CoverSizeAllowed reports whether a cover of the given size fits into MaxCoverSize
+func (o *ParseOptions) CoverSizeAllowed(size int64) bool {
+	return o == nil || o.MaxCoverSize <= 0 || size <= o.MaxCoverSize
+}
+
+func (o *ParseOptions) Log() *zerolog.Logger {
+	if o == nil || o.Logger == nil {
+		return &log.Logger
+	}
+	return o.Logger
+}
+
+// NormalizeISBN returns the ISBN-13 form of value, unless KeepRawISBN is set.
+// Invalid values are returned unchanged, use isbn.Validate to check them.
+func (o *ParseOptions) NormalizeISBN(value string) string {
+	if value == "" || (o != nil && o.KeepRawISBN) {
+		return value
+	}
+	normalized, err := isbn.Normalize(value)
+	if err != nil {
+		o.Log().Debug().Err(err).Msg("Invalid ISBN")
+		return value
+	}
+	return normalized
+}
+
+// NormalizeISBNs returns the identifiers with the ISBNs normalized by NormalizeISBN, the duplicates are removed
+func (o *ParseOptions) NormalizeISBNs(identifiers []Identifier) []Identifier {
+	ret := make([]Identifier, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		if identifier.Scheme == SCHEME_ISBN {
+			identifier.Value = o.NormalizeISBN(identifier.Value)
+		}
+		ret = AppendIdentifier(ret, identifier.Scheme, identifier.Value)
+	}
+	return ret
+}
@@ -0,0 +1,54 @@
+package eBookData
+
+import "slices"
+
+// MARC relator codes of the most common roles, see https://www.loc.gov/marc/relators/relaterm.html
+const (
+	ROLE_AUTHOR        = "aut"
+	ROLE_EDITOR        = "edt"
+	ROLE_TRANSLATOR    = "trl"
+	ROLE_ILLUSTRATOR   = "ill"
+	ROLE_NARRATOR      = "nrt"
+	ROLE_CONTRIBUTOR   = "ctb"
+	ROLE_BOOK_PRODUCER = "bkp"
+)
+
+// Person is a creator or contributor of a book
+type Person struct {
+	// Name is the display name, eg. "Jane Smith"
+	Name string
+	// FileAs is the sort name, eg. "Smith, Jane", empty if the book has none
+	FileAs string
+	// Roles are MARC relator codes, eg. ROLE_AUTHOR
+	Roles []string
+}
+
+// SortName returns FileAs, or Name if there is no sort name
+func (p Person) SortName() string {
+	if p.FileAs != "" {
+		return p.FileAs
+	}
+	return p.Name
+}
+
+func (p Person) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// IsAuthor reports whether the person is an author. A person without any role is treated as an author.
+func (p Person) IsAuthor() bool {
+	return len(p.Roles) == 0 || p.HasRole(ROLE_AUTHOR)
+}
+
+// Names returns the names of the persons in the given style
+func Names(persons []Person, style AuthorNameStyle) []string {
+	ret := make([]string, 0, len(persons))
+	for _, p := range persons {
+		if style == AUTHOR_NAME_FILE_AS {
+			ret = append(ret, p.SortName())
+		} else {
+			ret = append(ret, p.Name)
+		}
+	}
+	return ret
+}
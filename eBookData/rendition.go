@@ -0,0 +1,13 @@
+package eBookData
+
+// Rendition is a rootfile of a multiple rendition book (eg. fixed layout and reflowable, or more languages).
+// The fields other than FullPath and MediaType are the rendition selection attributes, empty if not given.
+type Rendition struct {
+	FullPath   string
+	MediaType  string
+	Label      string
+	Language   string
+	Layout     string
+	Media      string
+	AccessMode string
+}
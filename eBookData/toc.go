@@ -0,0 +1,16 @@
+package eBookData
+
+// TOCEntry is an entry of the table of contents
+type TOCEntry struct {
+	Label string
+	// Href is the name of the content file in the book, empty for entries without a link (eg. headings)
+	Href string
+	// Fragment is the target inside of the content file, without the #
+	Fragment string
+	Children []TOCEntry
+}
+
+// Navigable is implemented by the books that have a table of contents
+type Navigable interface {
+	TableOfContents() []TOCEntry
+}
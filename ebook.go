@@ -1 +1,134 @@
 package ebook
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
+	"github.com/ignisVeneficus/ebook/epub"
+)
+
+// EPUB_MIMETYPE is the content of the mimetype entry of an epub, defined by the epub package
+const EPUB_MIMETYPE = epub.EPUB_MIMETYPE
+
+// PalmDB type and creator of a Mobipocket file, stored at offset 60 of the header
+const MOBI_TYPE_CREATOR = "BOOKMOBI"
+const MOBI_TYPE_CREATOR_OFFSET = 60
+
+type UnsupportedFormatError struct {
+	Path string
+}
+
+func (e *UnsupportedFormatError) Error() string {
+	return fmt.Sprintf("Unsupported ebook format: %s", e.Path)
+}
+
+type EbookError struct {
+	msg  string
+	root error
+}
+
+func (e *EbookError) Error() string {
+	if e.root == nil {
+		return e.msg
+	}
+	return fmt.Sprintf("%s : %s", e.msg, e.root.Error())
+}
+
+func (e *EbookError) Unwrap() error {
+	return e.root
+}
+
+func createEbookError(msg string, root error) *EbookError {
+	return &EbookError{msg: msg, root: root}
+}
+
+func isEpub(r io.ReaderAt, size int64) bool {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return false
+	}
+	for _, file := range zipReader.File {
+		if file.Name != "mimetype" {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return false
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(io.LimitReader(reader, 64))
+		if err != nil {
+			return false
+		}
+		return strings.TrimSpace(string(data)) == EPUB_MIMETYPE
+	}
+	return false
+}
+
+func isMobi(r io.ReaderAt) bool {
+	buf := make([]byte, len(MOBI_TYPE_CREATOR))
+	if _, err := r.ReadAt(buf, MOBI_TYPE_CREATOR_OFFSET); err != nil {
+		return false
+	}
+	return bytes.Equal(buf, []byte(MOBI_TYPE_CREATOR))
+}
+
+// Parse reads the ebook at path and returns its metadata and cover.
+// The format is detected from the content of the file with the registered formats,
+// the extension is used only as a fallback.
+// Books of the formats that read their content lazily (EPUB) keep the file open, they implement io.Closer and have to be closed.
+func Parse(path string) (eBookData.Book, error) {
+	return ParseWithOptions(path, nil)
+}
+
+// ParseWithOptions is Parse with options passed to the reader of the detected format. opts can be nil for the defaults.
+func ParseWithOptions(path string, opts *eBookData.ParseOptions) (eBookData.Book, error) {
+	logger := opts.Log()
+	logger.Debug().Str("path", path).Msg("Start parsing ebook")
+	defer logger.Debug().Str("path", path).Msg("End parsing ebook")
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, createEbookError("Ebook file not readable", err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, createEbookError("Ebook file not readable", err)
+	}
+	if !opts.FileSizeAllowed(stat.Size()) {
+		f.Close()
+		return nil, createEbookError("Ebook file too large", nil)
+	}
+	format, ok := detectFormat(f, stat.Size(), path)
+	if !ok {
+		f.Close()
+		return nil, &UnsupportedFormatError{Path: path}
+	}
+	logger.Trace().Str("format", format.Name).Msg("Format detected")
+	if format.OpenFile != nil {
+		// the book opens the file again and owns it
+		f.Close()
+		return format.OpenFile(path, opts)
+	}
+	defer f.Close()
+	return format.Open(f, stat.Size(), opts)
+}
+
+// ParseReader reads an ebook from r. The name is only used as an extension hint
+// when the content is not recognized by any registered format.
+func ParseReader(r io.ReaderAt, size int64, name string, opts *eBookData.ParseOptions) (eBookData.Book, error) {
+	if !opts.FileSizeAllowed(size) {
+		return nil, createEbookError("Ebook file too large", nil)
+	}
+	format, ok := detectFormat(r, size, name)
+	if !ok {
+		return nil, &UnsupportedFormatError{Path: name}
+	}
+	opts.Log().Trace().Str("format", format.Name).Msg("Format detected")
+	return format.Open(r, size, opts)
+}
@@ -0,0 +1,140 @@
+package ebook
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
+	"github.com/ignisVeneficus/ebook/epub"
+)
+
+func createMinimalEpub(t *testing.T) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	w, _ := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	io.WriteString(w, EPUB_MIMETYPE)
+
+	w, _ = zw.Create("META-INF/container.xml")
+	io.WriteString(w, `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+	<rootfiles>
+		<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+	</rootfiles>
+</container>`)
+
+	w, _ = zw.Create("OEBPS/content.opf")
+	io.WriteString(w, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="BookId">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+		<dc:title>Sniffed EPUB</dc:title>
+	</metadata>
+</package>`)
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to create epub: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseReaderEpubWithoutExtension(t *testing.T) {
+	data := createMinimalEpub(t)
+	book, err := ParseReader(bytes.NewReader(data), int64(len(data)), "book.bin", nil)
+	if err != nil {
+		t.Fatalf("ParseReader failed: %v", err)
+	}
+	if book.Metadata().Title() != "Sniffed EPUB" {
+		t.Errorf("Expected title 'Sniffed EPUB', got '%s'", book.Metadata().Title())
+	}
+}
+
+func TestParseKeepsEpubOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.epub")
+	if err := os.WriteFile(path, createMinimalEpub(t), 0o644); err != nil {
+		t.Fatalf("Failed to write epub: %v", err)
+	}
+	book, err := Parse(path)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	epubBook, ok := book.(*epub.Epub)
+	if !ok {
+		t.Fatalf("Expected *epub.Epub, got %T", book)
+	}
+	data, err := fs.ReadFile(epubBook.FS(), "OEBPS/content.opf")
+	if err != nil || !bytes.Contains(data, []byte("Sniffed EPUB")) {
+		t.Errorf("Content not readable after Parse: %v", err)
+	}
+	if err := epubBook.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if _, err := fs.ReadFile(epubBook.FS(), "OEBPS/content.opf"); err == nil {
+		t.Errorf("Content should not be readable after Close")
+	}
+}
+
+func TestParseReaderUnsupported(t *testing.T) {
+	data := []byte("just some text, not an ebook")
+	_, err := ParseReader(bytes.NewReader(data), int64(len(data)), "notes.txt", nil)
+	var unsupported *UnsupportedFormatError
+	if !errors.As(err, &unsupported) {
+		t.Errorf("Expected UnsupportedFormatError, got %v", err)
+	}
+}
+
+type testBook struct{}
+
+func (testBook) Metadata() eBookData.Metadata { return nil }
+func (testBook) Cover() *eBookData.Cover      { return nil }
+
+func TestRegisterFormat(t *testing.T) {
+	saved := Formats()
+	t.Cleanup(func() {
+		formatsLock.Lock()
+		defer formatsLock.Unlock()
+		formats = saved
+	})
+	Register(Format{
+		Name:       "test",
+		Extensions: []string{".tst"},
+		Detect: func(r io.ReaderAt, size int64) bool {
+			buf := make([]byte, 4)
+			_, err := r.ReadAt(buf, 0)
+			return err == nil && string(buf) == "TEST"
+		},
+		Open: func(r io.ReaderAt, size int64, opts *eBookData.ParseOptions) (eBookData.Book, error) {
+			return testBook{}, nil
+		},
+	})
+	data := []byte("TEST book")
+	book, err := ParseReader(bytes.NewReader(data), int64(len(data)), "", nil)
+	if err != nil {
+		t.Fatalf("ParseReader failed: %v", err)
+	}
+	if _, ok := book.(testBook); !ok {
+		t.Errorf("Expected the registered format to be used, got %T", book)
+	}
+	// extension fallback
+	data = []byte("unknown content")
+	book, err = ParseReader(bytes.NewReader(data), int64(len(data)), "book.TST", nil)
+	if err != nil {
+		t.Fatalf("ParseReader failed: %v", err)
+	}
+	if _, ok := book.(testBook); !ok {
+		t.Errorf("Expected the extension fallback to be used, got %T", book)
+	}
+}
+
+func TestRegisterFormatRestored(t *testing.T) {
+	for _, format := range Formats() {
+		if format.Name == "test" {
+			t.Errorf("The test format should be removed after TestRegisterFormat")
+		}
+	}
+}
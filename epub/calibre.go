@@ -0,0 +1,140 @@
+package epub
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/rs/zerolog"
+)
+
+// calibreData is the metadata that calibre writes into the OPF
+type calibreData struct {
+	titleSort      string
+	rating         float64
+	timestamp      eBookData.Date
+	custom         map[string]any
+	userCategories map[string][]string
+}
+
+// parseCalibreMeta reads the first EPUB2 <meta name="calibre:x" content="..."/> or EPUB3 <meta property="calibre:x">...</meta>
+func parseCalibreMeta(doc *xmlquery.Node, nsMap map[string]string, name string, logger *zerolog.Logger) string {
+	return parseFirstText(doc, nsMap, "/opf:package/opf:metadata/opf:meta[@name='"+name+"']/@content | /opf:package/opf:metadata/opf:meta[@property='"+name+"' and not(@refines)]", name, logger)
+}
+
+// parseSeries reads the calibre series metas
+func parseSeries(doc *xmlquery.Node, nsMap map[string]string, logger *zerolog.Logger) (string, float64, error) {
+	series := parseCalibreMeta(doc, nsMap, "calibre:series", logger)
+	if series == "" {
+		return "", 0, nil
+	}
+	index := parseCalibreMeta(doc, nsMap, "calibre:series_index", logger)
+	if index == "" {
+		return series, 0, nil
+	}
+	seriesIndex, err := strconv.ParseFloat(index, 64)
+	if err != nil {
+		logger.Trace().Str("SeriesIndex", index).Msg("Invalid series index")
+		return series, 0, nil
+	}
+	return series, seriesIndex, nil
+}
+
+// customValue returns the value of a calibre custom column definition
+func customValue(definition any) (any, bool) {
+	column, ok := definition.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	value, ok := column["#value#"]
+	if !ok || value == nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// parseUserMetadata reads the calibre custom columns, keyed by the column label without the leading #.
+// EPUB2 has one meta per column (calibre:user_metadata:#label), EPUB3 has all columns in one JSON.
+func parseUserMetadata(doc *xmlquery.Node, nsMap map[string]string, logger *zerolog.Logger) map[string]any {
+	ret := make(map[string]any)
+	expr, _ := xpath.CompileWithNS("/opf:package/opf:metadata/opf:meta[starts-with(@name, 'calibre:user_metadata:')]", nsMap)
+	for _, node := range xmlquery.QuerySelectorAll(doc, expr) {
+		label := strings.TrimPrefix(strings.TrimPrefix(node.SelectAttr("name"), "calibre:user_metadata:"), "#")
+		var definition any
+		if err := json.Unmarshal([]byte(node.SelectAttr("content")), &definition); err != nil {
+			logger.Trace().Err(err).Str("Column", label).Msg("Invalid calibre user metadata")
+			continue
+		}
+		if value, ok := customValue(definition); ok {
+			ret[label] = value
+		}
+	}
+	content := parseFirstText(doc, nsMap, "/opf:package/opf:metadata/opf:meta[@property='calibre:user_metadata']", "UserMetadata", logger)
+	if content != "" {
+		columns := make(map[string]any)
+		if err := json.Unmarshal([]byte(content), &columns); err != nil {
+			logger.Trace().Err(err).Msg("Invalid calibre user metadata")
+		}
+		for label, definition := range columns {
+			if value, ok := customValue(definition); ok {
+				ret[strings.TrimPrefix(label, "#")] = value
+			}
+		}
+	}
+	return ret
+}
+
+// parseUserCategories reads the calibre user categories, the items of a category are [name, type, flag] lists
+func parseUserCategories(doc *xmlquery.Node, nsMap map[string]string, logger *zerolog.Logger) map[string][]string {
+	ret := make(map[string][]string)
+	content := parseCalibreMeta(doc, nsMap, "calibre:user_categories", logger)
+	if content == "" {
+		return ret
+	}
+	categories := make(map[string][][]any)
+	if err := json.Unmarshal([]byte(content), &categories); err != nil {
+		logger.Trace().Err(err).Msg("Invalid calibre user categories")
+		return ret
+	}
+	for category, items := range categories {
+		names := make([]string, 0, len(items))
+		for _, item := range items {
+			if len(item) == 0 {
+				continue
+			}
+			if name, ok := item[0].(string); ok {
+				names = append(names, name)
+			}
+		}
+		ret[category] = names
+	}
+	return ret
+}
+
+func parseCalibre(doc *xmlquery.Node, nsMap map[string]string, logger *zerolog.Logger) (calibreData, error) {
+	data := calibreData{
+		titleSort: parseCalibreMeta(doc, nsMap, "calibre:title_sort", logger),
+	}
+	if rating := parseCalibreMeta(doc, nsMap, "calibre:rating", logger); rating != "" {
+		if value, err := strconv.ParseFloat(rating, 64); err == nil {
+			// calibre stores the rating on 0-10 scale, 2 points a star
+			data.rating = value / 2
+		} else {
+			logger.Trace().Str("Rating", rating).Msg("Invalid calibre rating")
+		}
+	}
+	if timestamp := parseCalibreMeta(doc, nsMap, "calibre:timestamp", logger); timestamp != "" {
+		date, err := eBookData.ParseDate(timestamp)
+		if err != nil {
+			logger.Trace().Err(err).Msg("Invalid calibre timestamp")
+		}
+		data.timestamp = date
+	}
+	data.custom = parseUserMetadata(doc, nsMap, logger)
+	data.userCategories = parseUserCategories(doc, nsMap, logger)
+	return data, nil
+}
@@ -0,0 +1,81 @@
+package epub
+
+import (
+	"archive/zip"
+	"strings"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/rs/zerolog"
+)
+
+const CONTAINER_NS = "urn:oasis:names:tc:opendocument:xmlns:container"
+const RENDITION_NS = "http://www.idpf.org/2013/rendition"
+const PACKAGE_MEDIA_TYPE = "application/oebps-package+xml"
+const CONTAINER_PATH = "META-INF/container.xml"
+
+var containerNsMap = map[string]string{
+	"c": CONTAINER_NS,
+}
+
+// attrNS returns the attribute by its namespace, so it does not depend on the prefix used in the file
+func attrNS(node *xmlquery.Node, namespace string, name string) string {
+	for _, attr := range node.Attr {
+		if attr.Name.Local == name && attr.NamespaceURI == namespace {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// parseContainer returns the package renditions of META-INF/container.xml in order.
+// Rootfiles of other media types (eg. PDF) are skipped.
+func parseContainer(file *zip.File, logger *zerolog.Logger) ([]eBookData.Rendition, error) {
+	doc, err := readXml(file)
+	if err != nil {
+		return nil, err
+	}
+	expr, _ := xpath.CompileWithNS("/c:container/c:rootfiles/c:rootfile", containerNsMap)
+	nodes := xmlquery.QuerySelectorAll(doc, expr)
+	if len(nodes) == 0 {
+		// lenient: container without namespace
+		expr, _ = xpath.Compile("/container/rootfiles/rootfile")
+		nodes = xmlquery.QuerySelectorAll(doc, expr)
+	}
+	ret := make([]eBookData.Rendition, 0, len(nodes))
+	for _, node := range nodes {
+		rendition := eBookData.Rendition{
+			FullPath:   strings.TrimPrefix(strings.TrimSpace(node.SelectAttr("full-path")), "/"),
+			MediaType:  strings.TrimSpace(node.SelectAttr("media-type")),
+			Label:      attrNS(node, RENDITION_NS, "label"),
+			Language:   attrNS(node, RENDITION_NS, "language"),
+			Layout:     attrNS(node, RENDITION_NS, "layout"),
+			Media:      attrNS(node, RENDITION_NS, "media"),
+			AccessMode: attrNS(node, RENDITION_NS, "accessMode"),
+		}
+		if rendition.FullPath == "" {
+			continue
+		}
+		if rendition.MediaType != "" && rendition.MediaType != PACKAGE_MEDIA_TYPE {
+			logger.Trace().Str("FullPath", rendition.FullPath).Str("MediaType", rendition.MediaType).Msg("Not a package rootfile")
+			continue
+		}
+		logger.Trace().Str("FullPath", rendition.FullPath).Str("Layout", rendition.Layout).Str("Language", rendition.Language).Msg("Rendition parsed")
+		ret = append(ret, rendition)
+	}
+	return ret, nil
+}
+
+// selectRendition returns the rendition chosen by opts.SelectRendition, or the first (default) one
+func selectRendition(renditions []eBookData.Rendition, opts *eBookData.ParseOptions) eBookData.Rendition {
+	if opts != nil && opts.SelectRendition != nil {
+		idx := opts.SelectRendition(renditions)
+		if idx >= 0 && idx < len(renditions) {
+			return renditions[idx]
+		}
+		opts.Log().Debug().Int("index", idx).Msg("Invalid rendition selected, the default is used")
+	}
+	return renditions[0]
+}
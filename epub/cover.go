@@ -0,0 +1,323 @@
+package epub
+
+import (
+	"archive/zip"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/rs/zerolog"
+	"golang.org/x/net/html"
+)
+
+// coverRef is the cover file found in the book, href is the name of the file in the zip
+type coverRef struct {
+	href      string
+	mediaType string
+	source    eBookData.CoverSource
+}
+
+func mediaTypeOfFile(manifest []manifestItem, opfPath string, name string) string {
+	item, ok := findManifestItem(manifest, func(m manifestItem) bool { return resolveHref(opfPath, m.href) == name })
+	if !ok {
+		return ""
+	}
+	return item.mediaType
+}
+
+// parseCoverFile finds the EPUB2 <meta name="cover"> manifest item
+func parseCoverFile(doc *xmlquery.Node, nsMap map[string]string, logger *zerolog.Logger) (string, error) {
+	expr, _ := xpath.CompileWithNS("/opf:package/opf:metadata/opf:meta[@name='cover']/@content", nsMap)
+	nodes := xmlquery.QuerySelectorAll(doc, expr)
+	for _, node := range nodes {
+		coverId := node.InnerText()
+		logger.Trace().Str("CoverId", coverId).Msg("CoverId parsed")
+		return coverId, nil
+	}
+	logger.Trace().Msg("No CoverId found")
+	return "", nil
+}
+
+// parseGuideCover finds the <guide><reference type="cover"> href
+func parseGuideCover(doc *xmlquery.Node, nsMap map[string]string, logger *zerolog.Logger) (string, error) {
+	expr, _ := xpath.CompileWithNS("/opf:package/opf:guide/opf:reference", nsMap)
+	for _, node := range xmlquery.QuerySelectorAll(doc, expr) {
+		refType := strings.ToLower(node.SelectAttr("type"))
+		if refType == "cover" || refType == "other.cover" {
+			href := node.SelectAttr("href")
+			logger.Trace().Str("Href", href).Msg("Guide cover parsed")
+			return href, nil
+		}
+	}
+	logger.Trace().Msg("No guide cover found")
+	return "", nil
+}
+
+func readHtml(file *zip.File) (*html.Node, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, createCustomEpubFormatError(file.Name + " not readable")
+	}
+	defer reader.Close()
+	doc, err := html.Parse(reader)
+	if err != nil {
+		return nil, createEpubFormatError(err)
+	}
+	return doc, nil
+}
+
+func htmlAttr(node *html.Node, key string) string {
+	for _, attr := range node.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// hasEpubType checks the epub:type attribute, which is a space separated list
+func hasEpubType(node *html.Node, epubType string) bool {
+	for _, attr := range node.Attr {
+		if attr.Key == "epub:type" || (attr.Namespace == "epub" && attr.Key == "type") {
+			return slices.Contains(strings.Fields(attr.Val), epubType)
+		}
+	}
+	return false
+}
+
+// findHtml returns the first node in document order that matches
+func findHtml(node *html.Node, match func(*html.Node) bool) *html.Node {
+	if match(node) {
+		return node
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if found := findHtml(child, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// findImageInPage returns the href of the first <img> or svg <image> of an XHTML page
+func findImageInPage(file *zip.File) (string, error) {
+	doc, err := readHtml(file)
+	if err != nil {
+		return "", err
+	}
+	var href string
+	findHtml(doc, func(node *html.Node) bool {
+		if node.Type != html.ElementNode {
+			return false
+		}
+		switch node.Data {
+		case "img":
+			href = htmlAttr(node, "src")
+		case "image":
+			href = htmlAttr(node, "href")
+			if href == "" {
+				href = htmlAttr(node, "xlink:href")
+			}
+		}
+		return href != ""
+	})
+	return href, nil
+}
+
+// parseLandmarksCover finds the cover link in the landmarks of the EPUB3 navigation document
+func parseLandmarksCover(file *zip.File) (string, error) {
+	doc, err := readHtml(file)
+	if err != nil {
+		return "", err
+	}
+	landmarks := findHtml(doc, func(node *html.Node) bool {
+		return node.Type == html.ElementNode && node.Data == "nav" && hasEpubType(node, "landmarks")
+	})
+	if landmarks == nil {
+		return "", nil
+	}
+	link := findHtml(landmarks, func(node *html.Node) bool {
+		return node.Type == html.ElementNode && node.Data == "a" && hasEpubType(node, "cover")
+	})
+	if link == nil {
+		return "", nil
+	}
+	return htmlAttr(link, "href"), nil
+}
+
+type coverFinder struct {
+	doc      *xmlquery.Node
+	nsMap    map[string]string
+	opfPath  string
+	files    map[string]*zip.File
+	manifest []manifestItem
+	logger   *zerolog.Logger
+}
+
+// fromPage returns the cover from the href found in base, that can be an image or a cover page with an image
+func (c *coverFinder) fromPage(base string, href string, source eBookData.CoverSource) (coverRef, error) {
+	file, name := lookupHref(c.files, base, href)
+	if name == "" {
+		return coverRef{}, nil
+	}
+	mediaType := mediaTypeOfFile(c.manifest, c.opfPath, name)
+	if strings.HasPrefix(mediaType, "image/") {
+		return coverRef{href: name, mediaType: mediaType, source: source}, nil
+	}
+	if file == nil {
+		c.logger.Trace().Str("Page", name).Msg("Cover page not found")
+		return coverRef{}, nil
+	}
+	image, err := findImageInPage(file)
+	if err != nil || image == "" {
+		return coverRef{}, err
+	}
+	return c.fromItem(name, image, "", source), nil
+}
+
+// fromItem returns the cover of an image href found in base
+func (c *coverFinder) fromItem(base string, href string, mediaType string, source eBookData.CoverSource) coverRef {
+	_, name := lookupHref(c.files, base, href)
+	if name == "" {
+		return coverRef{}
+	}
+	if mediaType == "" {
+		mediaType = mediaTypeOfFile(c.manifest, c.opfPath, name)
+	}
+	return coverRef{href: name, mediaType: mediaType, source: source}
+}
+
+func (c *coverFinder) byProperties() (coverRef, error) {
+	item, ok := findManifestItem(c.manifest, func(m manifestItem) bool { return m.hasProperty("cover-image") })
+	if !ok {
+		return coverRef{}, nil
+	}
+	return c.fromItem(c.opfPath, item.href, item.mediaType, eBookData.COVER_SOURCE_PROPERTIES), nil
+}
+
+func (c *coverFinder) byMeta() (coverRef, error) {
+	coverId, err := parseCoverFile(c.doc, c.nsMap, c.logger)
+	if err != nil || coverId == "" {
+		return coverRef{}, err
+	}
+	item, ok := findManifestItem(c.manifest, func(m manifestItem) bool { return m.id == coverId })
+	if !ok {
+		// some books put the href into the meta
+		item, ok = findManifestItem(c.manifest, func(m manifestItem) bool { return m.href == coverId && m.isImage() })
+	}
+	if !ok {
+		c.logger.Trace().Str("CoverId", coverId).Msg("No manifest item for the cover")
+		return coverRef{}, nil
+	}
+	return c.fromItem(c.opfPath, item.href, item.mediaType, eBookData.COVER_SOURCE_META), nil
+}
+
+func (c *coverFinder) byGuide() (coverRef, error) {
+	href, err := parseGuideCover(c.doc, c.nsMap, c.logger)
+	if err != nil || href == "" {
+		return coverRef{}, err
+	}
+	return c.fromPage(c.opfPath, href, eBookData.COVER_SOURCE_GUIDE)
+}
+
+func (c *coverFinder) byLandmarks() (coverRef, error) {
+	item, ok := findManifestItem(c.manifest, func(m manifestItem) bool { return m.hasProperty("nav") })
+	if !ok {
+		return coverRef{}, nil
+	}
+	file, navName := lookupHref(c.files, c.opfPath, item.href)
+	if file == nil {
+		return coverRef{}, nil
+	}
+	href, err := parseLandmarksCover(file)
+	if err != nil || href == "" {
+		return coverRef{}, err
+	}
+	return c.fromPage(navName, href, eBookData.COVER_SOURCE_LANDMARKS)
+}
+
+// byCoverPage looks for an XHTML item that is named as a cover page
+func (c *coverFinder) byCoverPage() (coverRef, error) {
+	item, ok := findManifestItem(c.manifest, func(m manifestItem) bool {
+		if !m.isXhtml() {
+			return false
+		}
+		name := strings.ToLower(path.Base(m.href))
+		return strings.ToLower(m.id) == "cover" || strings.HasPrefix(name, "cover.") || strings.HasPrefix(name, "titlepage.")
+	})
+	if !ok {
+		return coverRef{}, nil
+	}
+	return c.fromPage(c.opfPath, item.href, eBookData.COVER_SOURCE_COVER_PAGE)
+}
+
+// COVER_SPINE_ITEMS is the number of XHTML documents at the start of the spine that are searched for a cover image
+const COVER_SPINE_ITEMS = 2
+
+// bySpine looks for the first image of the first spine documents, an unreadable document does not stop the search
+func (c *coverFinder) bySpine() (coverRef, error) {
+	var firstErr error
+	checked := 0
+	for _, idref := range parseSpine(c.doc, c.nsMap) {
+		if checked == COVER_SPINE_ITEMS {
+			break
+		}
+		item, ok := findManifestItem(c.manifest, func(m manifestItem) bool { return m.id == idref })
+		if !ok || !item.isXhtml() {
+			continue
+		}
+		checked++
+		cover, err := c.fromPage(c.opfPath, item.href, eBookData.COVER_SOURCE_SPINE)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if cover.href != "" {
+			return cover, firstErr
+		}
+	}
+	return coverRef{}, firstErr
+}
+
+// findCover goes through the cover definitions from the most specific to the least specific one
+// and returns the first that is found. The source of the result tells which one was used.
+// A definition that can't be read (eg. a broken page) does not stop the search, the first such error is returned with the result.
+func findCover(doc *xmlquery.Node, nsMap map[string]string, opfPath string, files map[string]*zip.File, logger *zerolog.Logger) (coverRef, error) {
+	finder := &coverFinder{
+		doc:      doc,
+		nsMap:    nsMap,
+		opfPath:  opfPath,
+		files:    files,
+		manifest: parseManifest(doc, nsMap, logger),
+		logger:   logger,
+	}
+	strategies := []func() (coverRef, error){
+		finder.byProperties,
+		finder.byMeta,
+		finder.byGuide,
+		finder.byLandmarks,
+		finder.byCoverPage,
+		finder.bySpine,
+	}
+	var firstErr error
+	for _, strategy := range strategies {
+		cover, err := strategy()
+		if err != nil {
+			logger.Trace().Err(err).Msg("Cover definition not readable")
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if cover.href != "" {
+			logger.Trace().Str("Cover", cover.href).Str("Source", string(cover.source)).Msg("Cover found")
+			return cover, firstErr
+		}
+	}
+	logger.Trace().Msg("No Cover found")
+	return coverRef{}, firstErr
+}
@@ -0,0 +1,962 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/rs/zerolog"
+)
+
+// Editor changes the metadata and the cover of an epub. Write creates a new archive where only the package document
+// and the replaced files differ, every other entry is copied byte-for-byte.
+type Editor struct {
+	epub     *Epub
+	opfPath  string
+	doc      *xmlquery.Node
+	metadata *xmlquery.Node
+	epub3    bool
+	// ids are the ids given to the new elements
+	ids map[string]bool
+	// replaced are the new contents of the files, by the name in the zip
+	replaced map[string][]byte
+	// added are the names of the new files in replaced, in order
+	added []string
+	// removed are the names of the files left out of the new zip
+	removed map[string]bool
+	// keepOpf copies the package document unchanged, when nothing was edited in it
+	keepOpf bool
+	logger  *zerolog.Logger
+}
+
+// Edit starts editing the epub. The reader the epub was read from has to be still open until Write.
+func (epub *Epub) Edit() (*Editor, error) {
+	opfPath := epub.rendition.FullPath
+	file := epub.files[opfPath]
+	if epub.zip == nil || file == nil {
+		return nil, createCustomEpubFormatError("No content.opf file")
+	}
+	doc, err := readXml(file)
+	if err != nil {
+		return nil, err
+	}
+	expr, _ := xpath.CompileWithNS("/opf:package/opf:metadata", opfNsMap)
+	metadata := xmlquery.QuerySelector(doc, expr)
+	if metadata == nil {
+		return nil, createCustomEpubFormatError("No metadata in " + opfPath)
+	}
+	return &Editor{
+		epub:     epub,
+		opfPath:  opfPath,
+		doc:      doc,
+		metadata: metadata,
+		epub3:    strings.HasPrefix(strings.TrimSpace(metadata.Parent.SelectAttr("version")), "3"),
+		ids:      make(map[string]bool),
+		replaced: make(map[string][]byte),
+		removed:  make(map[string]bool),
+		logger:   epub.log(),
+	}, nil
+}
+
+// Metadata returns the edited metadata
+func (e *Editor) Metadata() (eBookData.Metadata, error) {
+	metadata, err := parseMetadata(e.doc, &eBookData.ParseOptions{AuthorName: e.epub.metadata.nameStyle, Logger: e.logger})
+	if err != nil {
+		return nil, err
+	}
+	return *metadata, nil
+}
+
+func (e *Editor) selectAll(query string) []*xmlquery.Node {
+	expr, err := xpath.CompileWithNS(query, opfNsMap)
+	if err != nil {
+		panic(err)
+	}
+	return xmlquery.QuerySelectorAll(e.doc, expr)
+}
+
+func (e *Editor) refines() refinements {
+	refines, _ := parseRefines(e.doc, opfNsMap, e.logger)
+	return refines
+}
+
+// prefix returns the prefix of the namespace declared in the metadata or the package. For elements the default namespace
+// is preferred, that has empty prefix. If the namespace is not declared, it is declared on the metadata with the preferred prefix.
+func (e *Editor) prefix(uri string, preferred string, element bool) string {
+	if element {
+		for node := e.metadata; node != nil; node = node.Parent {
+			if idx := slices.IndexFunc(node.Attr, func(a xmlquery.Attr) bool { return a.Name.Space == "" && a.Name.Local == "xmlns" }); idx >= 0 {
+				if node.Attr[idx].Value == uri {
+					return ""
+				}
+				break
+			}
+		}
+	}
+	for node := e.metadata; node != nil; node = node.Parent {
+		for _, attr := range node.Attr {
+			if attr.Name.Space == "xmlns" && attr.Value == uri {
+				return attr.Name.Local
+			}
+		}
+	}
+	e.metadata.Attr = append(e.metadata.Attr, xmlquery.Attr{Name: xml.Name{Space: "xmlns", Local: preferred}, Value: uri})
+	return preferred
+}
+
+// newElement creates an element in the namespace, the attributes are key-value pairs, "opf:" attributes get the opf namespace
+func (e *Editor) newElement(uri string, preferred string, local string, text string, attrs ...string) *xmlquery.Node {
+	node := &xmlquery.Node{Type: xmlquery.ElementNode, Data: local, Prefix: e.prefix(uri, preferred, true), NamespaceURI: uri}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if attrs[i+1] == "" {
+			continue
+		}
+		attr := xmlquery.Attr{Name: xml.Name{Local: attrs[i]}, Value: attrs[i+1]}
+		if name, ok := strings.CutPrefix(attrs[i], "opf:"); ok {
+			attr.Name = xml.Name{Space: e.prefix(opfNsMap["opf"], "opf", false), Local: name}
+			attr.NamespaceURI = opfNsMap["opf"]
+		}
+		node.Attr = append(node.Attr, attr)
+	}
+	if text != "" {
+		xmlquery.AddChild(node, &xmlquery.Node{Type: xmlquery.TextNode, Data: text})
+	}
+	return node
+}
+
+func (e *Editor) newDc(local string, text string, attrs ...string) *xmlquery.Node {
+	return e.newElement(opfNsMap["dc"], "dc", local, text, attrs...)
+}
+
+func (e *Editor) newMeta(text string, attrs ...string) *xmlquery.Node {
+	return e.newElement(opfNsMap["opf"], "opf", "meta", text, attrs...)
+}
+
+// newId returns an id that is not used in the package document and was not given before
+func (e *Editor) newId(base string) string {
+	used := make(map[string]bool)
+	for _, node := range xmlquery.Find(e.doc, "//*[@id]") {
+		used[node.SelectAttr("id")] = true
+	}
+	for i := 1; ; i++ {
+		id := base + "-" + strconv.Itoa(i)
+		if !used[id] && !e.ids[id] {
+			e.ids[id] = true
+			return id
+		}
+	}
+}
+
+func setText(node *xmlquery.Node, text string) {
+	node.FirstChild, node.LastChild = nil, nil
+	xmlquery.AddChild(node, &xmlquery.Node{Type: xmlquery.TextNode, Data: text})
+}
+
+func isSpace(node *xmlquery.Node) bool {
+	return node != nil && node.Type == xmlquery.TextNode && strings.TrimSpace(node.Data) == ""
+}
+
+// indent returns the whitespace before the first child element of the parent,
+// or one level deeper than the parent if it has no indented child
+func indent(parent *xmlquery.Node) string {
+	if isSpace(parent.FirstChild) && parent.FirstChild.NextSibling != nil {
+		return parent.FirstChild.Data
+	}
+	if isSpace(parent.PrevSibling) {
+		return parent.PrevSibling.Data + "  "
+	}
+	return "\n"
+}
+
+func insertBefore(ref *xmlquery.Node, node *xmlquery.Node) {
+	node.Parent = ref.Parent
+	node.PrevSibling = ref.PrevSibling
+	node.NextSibling = ref
+	if ref.PrevSibling != nil {
+		ref.PrevSibling.NextSibling = node
+	} else {
+		ref.Parent.FirstChild = node
+	}
+	ref.PrevSibling = node
+}
+
+// insertChild puts the node before the ref element with the indentation of the parent, or at the end of the parent if ref is nil
+func insertChild(parent *xmlquery.Node, node *xmlquery.Node, ref *xmlquery.Node) {
+	space := &xmlquery.Node{Type: xmlquery.TextNode, Data: indent(parent)}
+	switch {
+	case ref != nil:
+		// the indentation before ref stays before node
+		insertBefore(ref, node)
+		insertBefore(ref, space)
+	case isSpace(parent.LastChild):
+		// the closing whitespace stays the last
+		insertBefore(parent.LastChild, space)
+		insertBefore(parent.LastChild, node)
+	default:
+		xmlquery.AddChild(parent, space)
+		xmlquery.AddChild(parent, node)
+		if isSpace(parent.PrevSibling) {
+			// the parent had no closing whitespace, the closing tag gets the indentation of the parent
+			xmlquery.AddChild(parent, &xmlquery.Node{Type: xmlquery.TextNode, Data: parent.PrevSibling.Data})
+		}
+	}
+}
+
+// insert puts the node into the metadata before the ref element, or at the end if ref is nil
+func (e *Editor) insert(node *xmlquery.Node, ref *xmlquery.Node) {
+	insertChild(e.metadata, node, ref)
+}
+
+// remove removes the node with the whitespace before it and its refinements
+func (e *Editor) remove(node *xmlquery.Node) {
+	if id := node.SelectAttr("id"); id != "" {
+		for _, refine := range e.selectAll("/opf:package/opf:metadata/opf:meta[@refines]") {
+			if strings.TrimSpace(refine.SelectAttr("refines")) == "#"+id {
+				e.remove(refine)
+			}
+		}
+	}
+	if isSpace(node.PrevSibling) {
+		xmlquery.RemoveFromTree(node.PrevSibling)
+	}
+	xmlquery.RemoveFromTree(node)
+}
+
+// replace puts the new nodes to the place of the first old one and removes the old nodes
+func (e *Editor) replace(old []*xmlquery.Node, nodes []*xmlquery.Node) {
+	var ref *xmlquery.Node
+	if len(old) > 0 {
+		ref = old[0]
+	}
+	for _, node := range nodes {
+		e.insert(node, ref)
+	}
+	for _, node := range old {
+		e.remove(node)
+	}
+}
+
+// refine adds an EPUB3 refinement of the element with the id
+func (e *Editor) refine(id string, property string, scheme string, value string) {
+	if value == "" {
+		return
+	}
+	e.insert(e.newMeta(value, "refines", "#"+id, "property", property, "scheme", scheme), nil)
+}
+
+// setTexts replaces the dc elements with the values, empty values are left out
+func (e *Editor) setTexts(local string, values ...string) {
+	nodes := make([]*xmlquery.Node, 0, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			nodes = append(nodes, e.newDc(local, value))
+		}
+	}
+	e.replace(e.selectAll("/opf:package/opf:metadata/dc:"+local), nodes)
+}
+
+// SetTitle changes the main title
+func (e *Editor) SetTitle(title string) error {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return createCustomEpubFormatError("Empty title")
+	}
+	refines := e.refines()
+	var main, first *xmlquery.Node
+	for _, node := range e.selectAll("/opf:package/opf:metadata/dc:title") {
+		switch refines.first(node.SelectAttr("id"), "title-type") {
+		case "main":
+			if main == nil {
+				main = node
+			}
+		case "subtitle":
+		default:
+			if first == nil {
+				first = node
+			}
+		}
+	}
+	if main == nil {
+		main = first
+	}
+	if main == nil {
+		e.insert(e.newDc("title", title), nil)
+		return nil
+	}
+	setText(main, title)
+	return nil
+}
+
+// SetAuthors replaces the creators with author role (or without role), the persons without role are written as authors
+func (e *Editor) SetAuthors(authors []eBookData.Person) {
+	refines := e.refines()
+	old := make([]*xmlquery.Node, 0)
+	for _, node := range e.selectAll("/opf:package/opf:metadata/dc:creator") {
+		roles := append([]string{node.SelectAttr("opf:role")}, refines.get(node.SelectAttr("id"), "role")...)
+		person := eBookData.Person{}
+		for _, role := range roles {
+			if role = strings.ToLower(strings.TrimSpace(role)); role != "" {
+				person.Roles = append(person.Roles, role)
+			}
+		}
+		if person.IsAuthor() {
+			old = append(old, node)
+		}
+	}
+	nodes := make([]*xmlquery.Node, 0, len(authors))
+	type refinement struct{ id, property, scheme, value string }
+	refinements := make([]refinement, 0)
+	for _, author := range authors {
+		if strings.TrimSpace(author.Name) == "" {
+			continue
+		}
+		roles := author.Roles
+		if len(roles) == 0 {
+			roles = []string{eBookData.ROLE_AUTHOR}
+		}
+		if !e.epub3 {
+			nodes = append(nodes, e.newDc("creator", author.Name, "opf:role", roles[0], "opf:file-as", author.FileAs))
+			continue
+		}
+		id := e.newId("creator")
+		nodes = append(nodes, e.newDc("creator", author.Name, "id", id))
+		refinements = append(refinements, refinement{id, "file-as", "", author.FileAs})
+		for _, role := range roles {
+			refinements = append(refinements, refinement{id, "role", "marc:relators", role})
+		}
+	}
+	e.replace(old, nodes)
+	for _, r := range refinements {
+		e.refine(r.id, r.property, r.scheme, r.value)
+	}
+}
+
+// SetSeries replaces the series collection and the calibre series metas, an empty name removes the series
+func (e *Editor) SetSeries(name string, index float64) {
+	old := e.selectAll("/opf:package/opf:metadata/opf:meta[@name='calibre:series' or @name='calibre:series_index' or @property='calibre:series' or @property='calibre:series_index']")
+	refines := e.refines()
+	// the collections without type are kept, see seriesCollection
+	for _, node := range e.selectAll("/opf:package/opf:metadata/opf:meta[@property='belongs-to-collection' and not(@refines)]") {
+		if refines.first(node.SelectAttr("id"), "collection-type") == "series" {
+			old = append(old, node)
+		}
+	}
+	name = strings.TrimSpace(name)
+	nodes := make([]*xmlquery.Node, 0)
+	if name != "" {
+		position := strconv.FormatFloat(index, 'f', -1, 64)
+		nodes = append(nodes, e.newMeta("", "name", "calibre:series", "content", name), e.newMeta("", "name", "calibre:series_index", "content", position))
+	}
+	e.replace(old, nodes)
+	if name != "" && e.epub3 {
+		id := e.newId("collection")
+		e.insert(e.newMeta(name, "property", "belongs-to-collection", "id", id), nil)
+		e.refine(id, "collection-type", "", "series")
+		if index != 0 {
+			e.refine(id, "group-position", "", strconv.FormatFloat(index, 'f', -1, 64))
+		}
+	}
+}
+
+// identifierNode returns the first dc:identifier of the scheme
+func (e *Editor) identifierNode(scheme string) *xmlquery.Node {
+	refines := e.refines()
+	for _, node := range e.selectAll("/opf:package/opf:metadata/dc:identifier") {
+		if nodeScheme, _ := identifierScheme(node, refines); nodeScheme == scheme {
+			return node
+		}
+	}
+	return nil
+}
+
+// SetIdentifier changes the identifier of the scheme, or adds a new one. The urn or other prefix of the old value is kept.
+func (e *Editor) SetIdentifier(scheme string, value string) error {
+	scheme = eBookData.NormalizeScheme(scheme)
+	value = strings.TrimSpace(value)
+	if scheme == "" || value == "" {
+		return createCustomEpubFormatError("Empty identifier")
+	}
+	if node := e.identifierNode(scheme); node != nil {
+		old := strings.TrimSpace(node.InnerText())
+		if prefixScheme, stripped := eBookData.ParseIdentifier(old); prefixScheme == scheme {
+			value = old[:len(old)-len(stripped)] + value
+		}
+		setText(node, value)
+		return nil
+	}
+	if !e.epub3 {
+		e.insert(e.newDc("identifier", value, "opf:scheme", scheme), nil)
+		return nil
+	}
+	id := e.newId("id")
+	e.insert(e.newDc("identifier", identifierValue(scheme, value), "id", id), nil)
+	if scheme != eBookData.SCHEME_ISBN && scheme != eBookData.SCHEME_UUID {
+		e.refine(id, "identifier-type", "", scheme)
+	}
+	return nil
+}
+
+// RemoveIdentifier removes the identifier of the scheme, the unique identifier of the package can not be removed
+func (e *Editor) RemoveIdentifier(scheme string) error {
+	node := e.identifierNode(eBookData.NormalizeScheme(scheme))
+	if node == nil {
+		return nil
+	}
+	if id := node.SelectAttr("id"); id != "" && id == e.metadata.Parent.SelectAttr("unique-identifier") {
+		return createCustomEpubFormatError("The unique identifier can not be removed")
+	}
+	e.remove(node)
+	return nil
+}
+
+func (e *Editor) SetPublisher(publisher string) {
+	e.setTexts("publisher", publisher)
+}
+func (e *Editor) SetLanguage(language string) {
+	e.setTexts("language", language)
+}
+func (e *Editor) SetDescription(description string) {
+	e.setTexts("description", description)
+}
+func (e *Editor) SetSubjects(subjects []string) {
+	e.setTexts("subject", subjects...)
+}
+func (e *Editor) SetRights(rights string) {
+	e.setTexts("rights", rights)
+}
+
+// SetPublishedDate replaces the publication date, the EPUB2 dates of other events are kept
+func (e *Editor) SetPublishedDate(date eBookData.Date) {
+	old := make([]*xmlquery.Node, 0)
+	for _, node := range e.selectAll("/opf:package/opf:metadata/dc:date") {
+		event := strings.ToLower(node.SelectAttr("opf:event"))
+		if event == "" || event == "publication" {
+			old = append(old, node)
+		}
+	}
+	nodes := make([]*xmlquery.Node, 0)
+	if !date.IsZero() {
+		nodes = append(nodes, e.newDc("date", date.String()))
+	}
+	e.replace(old, nodes)
+}
+
+// ReplaceCover changes the content of the cover image file. If mediaType is empty, it is sniffed from the data.
+// A cover file that is defined but missing from the zip is added. If the media type changes, the file gets the extension
+// of the new type and the links of the content documents, the NCX and the navigation document are changed to the new name.
+func (e *Editor) ReplaceCover(data []byte, mediaType string) error {
+	cover, err := findCover(e.doc, opfNsMap, e.opfPath, e.epub.files, e.logger)
+	if cover.href == "" {
+		if err != nil {
+			return err
+		}
+		return createCustomEpubFormatError("No cover to replace")
+	}
+	newCover := eBookData.NewCover(data, mediaType, cover.source)
+	item := e.manifestItem(cover.href)
+	if mediaTypeByExtension(cover.href) != newCover.MediaType && (item == nil || item.SelectAttr("media-type") != newCover.MediaType) {
+		return e.renameCover(cover.href, item, newCover.MediaType, data)
+	}
+	if item != nil {
+		item.SetAttr("media-type", newCover.MediaType)
+	}
+	if _, pending := e.replaced[cover.href]; e.epub.files[cover.href] == nil && !pending {
+		e.logger.Trace().Str("Cover", cover.href).Msg("Missing cover file added")
+		e.addFile(cover.href, data)
+		return nil
+	}
+	delete(e.removed, cover.href)
+	e.replaced[cover.href] = data
+	return nil
+}
+
+// renameCover replaces the cover with a file that has the extension of the new media type.
+// Nothing is changed, if a link to the old file can't be changed.
+func (e *Editor) renameCover(oldName string, item *xmlquery.Node, mediaType string, data []byte) error {
+	ext, ok := coverExtensions[mediaType]
+	if !ok {
+		return createCustomEpubFormatError("Unsupported cover media type " + mediaType)
+	}
+	base := path.Base(oldName)
+	name := e.newFileIn(path.Dir(oldName), strings.TrimSuffix(base, path.Ext(base)), ext)
+	if !e.relink(oldName, name, func(node *xmlquery.Node) bool { return node != item && isXmlDocument(node) }) {
+		return createCustomEpubFormatError("Links to the cover " + oldName + " can't be changed")
+	}
+	e.removeFile(oldName)
+	if item != nil {
+		item.SetAttr("href", hrefEscape(relativeHref(e.opfPath, name)))
+		item.SetAttr("media-type", mediaType)
+	}
+	e.addFile(name, data)
+	e.logger.Trace().Str("Cover", name).Str("Old", oldName).Msg("Cover renamed")
+	return nil
+}
+
+// coverExtensions are the file extensions of the supported cover media types
+var coverExtensions = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+}
+
+// CoverOptions controls SetCover
+type CoverOptions struct {
+	// CoverPage generates a cover XHTML page at the start of the spine. An old cover page at the start of the spine is removed,
+	// if only the NCX and the navigation document link to it, their links are changed to the new page.
+	CoverPage bool
+}
+
+func (o *CoverOptions) coverPage() bool {
+	return o != nil && o.CoverPage
+}
+
+func (e *Editor) selectOne(query string) *xmlquery.Node {
+	nodes := e.selectAll(query)
+	if len(nodes) == 0 {
+		return nil
+	}
+	return nodes[0]
+}
+
+// manifestItem returns the manifest item of the file in the zip
+func (e *Editor) manifestItem(name string) *xmlquery.Node {
+	for _, node := range e.selectAll("/opf:package/opf:manifest/opf:item") {
+		if resolveHref(e.opfPath, node.SelectAttr("href")) == name {
+			return node
+		}
+	}
+	return nil
+}
+
+func (e *Editor) manifestItemById(id string) *xmlquery.Node {
+	for _, node := range e.selectAll("/opf:package/opf:manifest/opf:item") {
+		if node.SelectAttr("id") == id {
+			return node
+		}
+	}
+	return nil
+}
+
+func (e *Editor) newOpfElement(local string, attrs ...string) *xmlquery.Node {
+	return e.newElement(opfNsMap["opf"], "opf", local, "", attrs...)
+}
+
+// readFile returns the new content of the file, or the content in the zip
+func (e *Editor) readFile(name string) ([]byte, error) {
+	if data, ok := e.replaced[name]; ok {
+		return data, nil
+	}
+	file := e.epub.files[name]
+	if file == nil || e.removed[name] {
+		return nil, createCustomEpubFormatError("No file " + name)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return nil, createEpubFormatError(err)
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func (e *Editor) addFile(name string, data []byte) {
+	e.replaced[name] = data
+	e.added = append(e.added, name)
+}
+
+func (e *Editor) removeFile(name string) {
+	delete(e.replaced, name)
+	e.added = slices.DeleteFunc(e.added, func(added string) bool { return added == name })
+	if e.epub.files[name] != nil {
+		e.removed[name] = true
+	}
+}
+
+// newFile returns an unused file name in the folder of the package document, the names of the removed files can be used again
+func (e *Editor) newFile(base string, ext string) string {
+	return e.newFileIn(path.Dir(e.opfPath), base, ext)
+}
+
+// newFileIn returns an unused file name in the folder
+func (e *Editor) newFileIn(dir string, base string, ext string) string {
+	taken := func(name string) bool {
+		_, replaced := e.replaced[name]
+		return (e.epub.files[name] != nil && !e.removed[name]) || replaced
+	}
+	name := path.Join(dir, base+ext)
+	for i := 1; taken(name); i++ {
+		name = path.Join(dir, base+"-"+strconv.Itoa(i)+ext)
+	}
+	return name
+}
+
+func removeProperty(node *xmlquery.Node, property string) {
+	properties := slices.DeleteFunc(strings.Fields(node.SelectAttr("properties")), func(p string) bool { return p == property })
+	if len(properties) == 0 {
+		node.RemoveAttr("properties")
+		return
+	}
+	node.SetAttr("properties", strings.Join(properties, " "))
+}
+
+// SetCover adds the image as the new cover: a manifest item with the EPUB3 cover-image property (in EPUB3 packages)
+// and the EPUB2 <meta name="cover">. The old cover image is removed if nothing else refers to it.
+// If mediaType is empty, it is sniffed from the data. opts can be nil for the defaults.
+func (e *Editor) SetCover(data []byte, mediaType string, opts *CoverOptions) error {
+	cover := eBookData.NewCover(data, mediaType, eBookData.COVER_SOURCE_PROPERTIES)
+	ext, ok := coverExtensions[cover.MediaType]
+	if !ok {
+		return createCustomEpubFormatError("Unsupported cover media type " + cover.MediaType)
+	}
+	manifest := e.selectOne("/opf:package/opf:manifest")
+	if manifest == nil {
+		return createCustomEpubFormatError("No manifest in " + e.opfPath)
+	}
+	old, err := findCover(e.doc, opfNsMap, e.opfPath, e.epub.files, e.logger)
+	if err != nil {
+		e.logger.Trace().Err(err).Str("Old", old.href).Msg("Old cover not fully readable")
+	}
+
+	name := e.newFile("cover", ext)
+	id := e.newId("cover-image")
+	for _, node := range e.selectAll("/opf:package/opf:manifest/opf:item[@properties]") {
+		removeProperty(node, "cover-image")
+	}
+	properties := ""
+	if e.epub3 {
+		properties = "cover-image"
+	}
+	insertChild(manifest, e.newOpfElement("item", "id", id, "href", hrefEscape(path.Base(name)), "media-type", cover.MediaType, "properties", properties), nil)
+	e.addFile(name, data)
+	e.replace(e.selectAll("/opf:package/opf:metadata/opf:meta[@name='cover']"), []*xmlquery.Node{e.newMeta("", "name", "cover", "content", id)})
+
+	if opts.coverPage() {
+		if err := e.addCoverPage(name, old.href); err != nil {
+			return err
+		}
+	}
+	if old.href != "" && old.href != name {
+		if err := e.removeUnreferenced(old.href); err != nil {
+			return err
+		}
+	}
+	e.logger.Trace().Str("Cover", name).Str("Old", old.href).Msg("Cover set")
+	return nil
+}
+
+// coverPage returns the XHTML page that shows the image
+func coverPage(image string, epub3 bool) []byte {
+	doctype, bodyType := "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.1//EN\" \"http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd\">", ""
+	if epub3 {
+		doctype, bodyType = "<!DOCTYPE html>", " epub:type=\"cover\""
+	}
+	return []byte(xml.Header + doctype + `
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+<title>Cover</title>
+<style type="text/css">body { margin: 0; padding: 0; text-align: center; } img { max-width: 100%; max-height: 100%; }</style>
+</head>
+<body` + bodyType + `>
+<div><img src="` + xmlEscape(hrefEscape(image)) + `" alt="Cover"/></div>
+</body>
+</html>
+`)
+}
+
+// isCoverPage reports whether the XHTML page shows the image
+func (e *Editor) isCoverPage(page string, image string) bool {
+	file := e.epub.files[page]
+	if file == nil || e.removed[page] {
+		return false
+	}
+	href, err := findImageInPage(file)
+	return err == nil && href != "" && resolveHref(page, href) == image
+}
+
+// addCoverPage puts a page with the image to the start of the spine and to the guide.
+// The first page of the spine is removed, if it shows the old cover and no other content document links to it.
+// The links of the NCX and the navigation document to the removed page are changed to the new one.
+func (e *Editor) addCoverPage(image string, oldImage string) error {
+	spine := e.selectOne("/opf:package/opf:spine")
+	if spine == nil {
+		return createCustomEpubFormatError("No spine in " + e.opfPath)
+	}
+	var oldPage string
+	var oldItem, oldRef *xmlquery.Node
+	if first := e.selectOne("/opf:package/opf:spine/opf:itemref"); first != nil && oldImage != "" {
+		if item := e.manifestItemById(first.SelectAttr("idref")); item != nil {
+			page := resolveHref(e.opfPath, item.SelectAttr("href"))
+			if e.isCoverPage(page, oldImage) && !e.pageReferenced(page, item) {
+				oldPage, oldItem, oldRef = page, item, first
+				// the name of the old page can be used by the new one
+				e.removeFile(page)
+			}
+		}
+	}
+
+	name := e.newFile("cover", ".xhtml")
+	if oldPage != "" {
+		if name == oldPage || e.relinkPage(oldPage, name) {
+			e.logger.Trace().Str("Page", oldPage).Msg("Old cover page removed")
+			e.removeGuideReferences(oldPage)
+			e.remove(oldRef)
+			e.remove(oldItem)
+		} else {
+			e.logger.Trace().Str("Page", oldPage).Msg("Links to the old cover page not changed, kept")
+			delete(e.removed, oldPage)
+			name = e.newFile("cover", ".xhtml")
+		}
+	}
+
+	id := e.newId("cover-page")
+	e.addFile(name, coverPage(path.Base(image), e.epub3))
+	manifest := e.selectOne("/opf:package/opf:manifest")
+	insertChild(manifest, e.newOpfElement("item", "id", id, "href", hrefEscape(path.Base(name)), "media-type", "application/xhtml+xml"), nil)
+	insertChild(spine, e.newOpfElement("itemref", "idref", id), e.selectOne("/opf:package/opf:spine/opf:itemref"))
+
+	guide := e.selectOne("/opf:package/opf:guide")
+	if guide == nil && e.epub3 {
+		return nil
+	}
+	if guide == nil {
+		guide = e.newOpfElement("guide")
+		insertChild(spine.Parent, guide, nil)
+	}
+	for _, reference := range e.selectAll("/opf:package/opf:guide/opf:reference") {
+		if strings.ToLower(reference.SelectAttr("type")) == "cover" {
+			e.remove(reference)
+		}
+	}
+	insertChild(guide, e.newOpfElement("reference", "type", "cover", "title", "Cover", "href", hrefEscape(path.Base(name))), nil)
+	return nil
+}
+
+// isLinkDocument reports whether the manifest item is the NCX or the navigation document, their links to the old cover page are changed
+func isLinkDocument(item *xmlquery.Node) bool {
+	return item.SelectAttr("media-type") == "application/x-dtbncx+xml" || slices.Contains(strings.Fields(item.SelectAttr("properties")), "nav")
+}
+
+// linkValues returns the distinct values of the href and src attributes of the document that point to the target, name is the zip name of the document
+func linkValues(doc *xmlquery.Node, name string, target string) []string {
+	values := make([]string, 0)
+	for _, node := range xmlquery.Find(doc, "//*") {
+		for _, attr := range node.Attr {
+			if (attr.Name.Local == "href" || attr.Name.Local == "src") && resolveHref(name, attr.Value) == target && !slices.Contains(values, attr.Value) {
+				values = append(values, attr.Value)
+			}
+		}
+	}
+	return values
+}
+
+// pageReferenced reports whether the page is in the spine more than once, or a content document other than the NCX
+// and the navigation document links to it. If a document can't be parsed, the name of the page is searched in it.
+func (e *Editor) pageReferenced(page string, item *xmlquery.Node) bool {
+	refs := 0
+	for _, itemref := range e.selectAll("/opf:package/opf:spine/opf:itemref") {
+		if itemref.SelectAttr("idref") == item.SelectAttr("id") {
+			refs++
+		}
+	}
+	if refs > 1 {
+		return true
+	}
+	for _, node := range e.selectAll("/opf:package/opf:manifest/opf:item") {
+		mediaType := node.SelectAttr("media-type")
+		if node == item || isLinkDocument(node) || (mediaType != "application/xhtml+xml" && mediaType != "text/html" && mediaType != "image/svg+xml") {
+			continue
+		}
+		file := resolveHref(e.opfPath, node.SelectAttr("href"))
+		data, err := e.readFile(file)
+		if err != nil {
+			continue
+		}
+		doc, err := xmlquery.Parse(bytes.NewReader(data))
+		if err != nil {
+			if bytes.Contains(data, []byte(path.Base(page))) {
+				return true
+			}
+			continue
+		}
+		if len(linkValues(doc, file, page)) > 0 {
+			e.logger.Trace().Str("Page", page).Str("File", file).Msg("Old cover page is referred")
+			return true
+		}
+	}
+	return false
+}
+
+// isXmlDocument reports whether the manifest item is an XML document that can link to other files
+func isXmlDocument(item *xmlquery.Node) bool {
+	mediaType := item.SelectAttr("media-type")
+	return mediaType == "application/xhtml+xml" || mediaType == "image/svg+xml" || mediaType == "application/x-dtbncx+xml"
+}
+
+// relinkPage changes the links of the NCX and the navigation document from the old page to the new one
+func (e *Editor) relinkPage(oldPage string, newPage string) bool {
+	return e.relink(oldPage, newPage, isLinkDocument)
+}
+
+// relink changes the links of the selected documents from the old file to the new one, the fragments are kept.
+// The attribute values are replaced in the text of the files, that keeps the rest of the files unchanged.
+// It reports false and changes nothing, if a file can't be parsed or a link is not found in the text.
+func (e *Editor) relink(oldName string, newName string, selected func(item *xmlquery.Node) bool) bool {
+	changes := make(map[string][]byte)
+	for _, node := range e.selectAll("/opf:package/opf:manifest/opf:item") {
+		if !selected(node) {
+			continue
+		}
+		file := resolveHref(e.opfPath, node.SelectAttr("href"))
+		data, err := e.readFile(file)
+		if err != nil {
+			continue
+		}
+		doc, err := xmlquery.Parse(bytes.NewReader(data))
+		if err != nil {
+			return false
+		}
+		values := linkValues(doc, file, oldName)
+		for _, value := range values {
+			newValue := hrefEscape(relativeHref(file, newName))
+			if i := strings.Index(value, "#"); i >= 0 {
+				newValue += value[i:]
+			}
+			found := false
+			for _, quote := range []string{"\"", "'"} {
+				old := []byte("=" + quote + xmlEscape(value) + quote)
+				if bytes.Contains(data, old) {
+					data = bytes.ReplaceAll(data, old, []byte("="+quote+xmlEscape(newValue)+quote))
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		if len(values) > 0 {
+			changes[file] = data
+		}
+	}
+	for file, data := range changes {
+		e.logger.Trace().Str("File", file).Str("Old", oldName).Str("New", newName).Msg("Links changed")
+		e.replaced[file] = data
+	}
+	return true
+}
+
+func (e *Editor) removeGuideReferences(name string) {
+	for _, reference := range e.selectAll("/opf:package/opf:guide/opf:reference") {
+		if resolveHref(e.opfPath, reference.SelectAttr("href")) == name {
+			e.remove(reference)
+		}
+	}
+}
+
+// removeUnreferenced removes the image from the manifest and the zip, if no content document, stylesheet,
+// navigation file or guide refers to it. The name of the file is searched in the content, that keeps the image if unsure.
+func (e *Editor) removeUnreferenced(name string) error {
+	item := e.manifestItem(name)
+	if item == nil {
+		return nil
+	}
+	base := path.Base(name)
+	for _, node := range e.selectAll("/opf:package/opf:manifest/opf:item") {
+		mediaType := node.SelectAttr("media-type")
+		if node == item || (mediaType != "application/xhtml+xml" && mediaType != "text/html" && mediaType != "text/css" &&
+			mediaType != "image/svg+xml" && mediaType != "application/x-dtbncx+xml") {
+			continue
+		}
+		file := resolveHref(e.opfPath, node.SelectAttr("href"))
+		data, err := e.readFile(file)
+		if err != nil {
+			continue
+		}
+		if bytes.Contains(data, []byte(base)) || bytes.Contains(data, []byte(hrefEscape(base))) {
+			e.logger.Trace().Str("Cover", name).Str("File", file).Msg("Old cover is referred, kept")
+			return nil
+		}
+	}
+	for _, reference := range e.selectAll("/opf:package/opf:guide/opf:reference") {
+		if resolveHref(e.opfPath, reference.SelectAttr("href")) == name {
+			return nil
+		}
+	}
+	e.logger.Trace().Str("Cover", name).Msg("Old cover removed")
+	e.remove(item)
+	e.removeFile(name)
+	return nil
+}
+
+// touchModified sets the EPUB3 dcterms:modified to the current time
+func (e *Editor) touchModified() {
+	if !e.epub3 {
+		return
+	}
+	modified := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	old := e.selectAll("/opf:package/opf:metadata/opf:meta[@property='dcterms:modified' and not(@refines)]")
+	e.replace(old, []*xmlquery.Node{e.newMeta(modified, "property", "dcterms:modified")})
+}
+
+func writeEditedFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Write writes the edited epub: mimetype first and stored, the new package document and the replaced files,
+// every other entry is copied without recompression in the original order, the new files are at the end. The EPUB3 modification date is updated.
+func (e *Editor) Write(w io.Writer) error {
+	var opf []byte
+	if !e.keepOpf {
+		e.touchModified()
+		opf = []byte(e.doc.OutputXMLWithOptions(xmlquery.WithEmptyTagSupport(), xmlquery.WithPreserveSpace()))
+	}
+	zw := zip.NewWriter(w)
+	replacedQrt := 0
+	if err := writeMimetype(zw); err != nil {
+		return createEpubWriteError(err)
+	}
+	for _, file := range e.epub.zip.File {
+		data, replaced := e.replaced[file.Name]
+		if file.Name == e.opfPath && !e.keepOpf {
+			data, replaced = opf, true
+		}
+		var err error
+		switch {
+		case file.Name == "mimetype" || e.removed[file.Name]:
+			continue
+		case replaced:
+			replacedQrt++
+			err = writeEditedFile(zw, file.Name, data)
+		default:
+			err = zw.Copy(file)
+		}
+		if err != nil {
+			return createEpubWriteError(err)
+		}
+	}
+	for _, name := range e.added {
+		if err := writeEditedFile(zw, name, e.replaced[name]); err != nil {
+			return createEpubWriteError(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return createEpubWriteError(err)
+	}
+	e.logger.Trace().Int("Replaced qrt", replacedQrt).Int("Added qrt", len(e.added)).Int("Removed qrt", len(e.removed)).Msg("Edited epub written")
+	return nil
+}
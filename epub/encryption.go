@@ -0,0 +1,141 @@
+package epub
+
+import (
+	"archive/zip"
+	"strings"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/rs/zerolog"
+)
+
+const ENCRYPTION_PATH = "META-INF/encryption.xml"
+const RIGHTS_PATH = "META-INF/rights.xml"
+const LCP_LICENSE_PATH = "META-INF/license.lcpl"
+const FAIRPLAY_SINF_PATH = "META-INF/sinf.xml"
+
+const ADEPT_NS = "http://ns.adobe.com/adept"
+
+// the algorithms of the font obfuscation, the key is the unique identifier of the book
+const IDPF_FONT_ALGORITHM = "http://www.idpf.org/2008/embedding"
+const ADOBE_FONT_ALGORITHM = "http://ns.adobe.com/pdf/enc#RC"
+
+const FAIRPLAY_ALGORITHM = "http://itunes.apple.com/dataenc"
+const LCP_KEY_TYPE = "http://readium.org/2014/01/lcp#EncryptedContentKey"
+
+// encryptionInfo is what META-INF/encryption.xml tells about the scheme
+type encryptionInfo struct {
+	resources []eBookData.EncryptedResource
+	fairplay  bool
+	lcp       bool
+	adept     bool
+}
+
+// parseEncryptionXml returns the encrypted resources of META-INF/encryption.xml. The elements are matched by local name,
+// because the files in the wild use the xmlenc namespace with different prefixes or without namespace.
+func parseEncryptionXml(file *zip.File, logger *zerolog.Logger) (encryptionInfo, error) {
+	info := encryptionInfo{}
+	doc, err := readXml(file)
+	if err != nil {
+		return info, err
+	}
+	for _, data := range xmlquery.Find(doc, "//*[local-name()='EncryptedData']") {
+		resource := eBookData.EncryptedResource{}
+		if method := xmlquery.FindOne(data, "./*[local-name()='EncryptionMethod']"); method != nil {
+			resource.Algorithm = strings.TrimSpace(method.SelectAttr("Algorithm"))
+		}
+		if reference := xmlquery.FindOne(data, "./*[local-name()='CipherData']/*[local-name()='CipherReference']"); reference != nil {
+			// the URIs are relative to the root of the container
+			resource.Path = resolveHref("", reference.SelectAttr("URI"))
+		}
+		if resource.Path == "" {
+			logger.Trace().Str("Algorithm", resource.Algorithm).Msg("Encrypted data without resource skipped")
+			continue
+		}
+		resource.Obfuscated = resource.Algorithm == IDPF_FONT_ALGORITHM || resource.Algorithm == ADOBE_FONT_ALGORITHM
+		if resource.Algorithm == FAIRPLAY_ALGORITHM {
+			info.fairplay = true
+		}
+		for _, method := range xmlquery.Find(data, "./*[local-name()='KeyInfo']/*[local-name()='RetrievalMethod']") {
+			if method.SelectAttr("Type") == LCP_KEY_TYPE {
+				info.lcp = true
+			}
+		}
+		for _, key := range xmlquery.Find(data, "./*[local-name()='KeyInfo']/*") {
+			if key.NamespaceURI == ADEPT_NS {
+				info.adept = true
+			}
+		}
+		logger.Trace().Str("Path", resource.Path).Str("Algorithm", resource.Algorithm).Msg("Encrypted resource parsed")
+		info.resources = append(info.resources, resource)
+	}
+	return info, nil
+}
+
+// adeptScheme tells the Adobe scheme from META-INF/rights.xml. Barnes & Noble uses the Adobe scheme
+// with a shorter key made from a passphrase, that is marked with keyType="passhash" in the newer files.
+func adeptScheme(file *zip.File) (eBookData.EncryptionScheme, error) {
+	doc, err := readXml(file)
+	if err != nil {
+		return eBookData.ENCRYPTION_UNKNOWN, err
+	}
+	for _, key := range xmlquery.Find(doc, "//*[local-name()='encryptedKey']") {
+		if key.NamespaceURI != ADEPT_NS {
+			continue
+		}
+		if key.SelectAttr("keyType") == "passhash" || len(strings.TrimSpace(key.InnerText())) == 64 {
+			return eBookData.ENCRYPTION_BARNES_NOBLE, nil
+		}
+		return eBookData.ENCRYPTION_ADOBE_ADEPT, nil
+	}
+	if root := xmlquery.FindOne(doc, "/*"); root != nil && root.NamespaceURI == ADEPT_NS {
+		return eBookData.ENCRYPTION_ADOBE_ADEPT, nil
+	}
+	return eBookData.ENCRYPTION_NONE, nil
+}
+
+// parseEncryption classifies the protection of the epub from META-INF/encryption.xml, rights.xml and the license files of the DRM schemes.
+// If a file is not readable, the scheme is unknown and the error is returned with the resources found so far.
+func parseEncryption(files map[string]*zip.File, logger *zerolog.Logger) (eBookData.Encryption, error) {
+	encryption := eBookData.Encryption{}
+	info := encryptionInfo{}
+	if file := files[ENCRYPTION_PATH]; file != nil {
+		var err error
+		if info, err = parseEncryptionXml(file, logger); err != nil {
+			encryption.Scheme = eBookData.ENCRYPTION_UNKNOWN
+			return encryption, err
+		}
+	}
+	encryption.Resources = info.resources
+	rightsScheme := eBookData.ENCRYPTION_NONE
+	if file := files[RIGHTS_PATH]; file != nil {
+		var err error
+		if rightsScheme, err = adeptScheme(file); err != nil {
+			encryption.Scheme = eBookData.ENCRYPTION_UNKNOWN
+			return encryption, err
+		}
+	}
+	encrypted := false
+	for _, resource := range info.resources {
+		if !resource.Obfuscated {
+			encrypted = true
+		}
+	}
+	switch {
+	case info.lcp || files[LCP_LICENSE_PATH] != nil:
+		encryption.Scheme = eBookData.ENCRYPTION_READIUM_LCP
+	case info.fairplay || files[FAIRPLAY_SINF_PATH] != nil:
+		encryption.Scheme = eBookData.ENCRYPTION_APPLE_FAIRPLAY
+	case rightsScheme != eBookData.ENCRYPTION_NONE:
+		encryption.Scheme = rightsScheme
+	case info.adept:
+		encryption.Scheme = eBookData.ENCRYPTION_ADOBE_ADEPT
+	case encrypted:
+		encryption.Scheme = eBookData.ENCRYPTION_UNKNOWN
+	case len(info.resources) > 0:
+		encryption.Scheme = eBookData.ENCRYPTION_FONT_OBFUSCATION
+	}
+	logger.Trace().Str("Scheme", string(encryption.Scheme)).Int("Resources qrt", len(encryption.Resources)).Msg("Encryption parsed")
+	return encryption, nil
+}
@@ -5,7 +5,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/fs"
 	"maps"
+	"os"
 	"slices"
 	"strings"
 
@@ -13,6 +15,7 @@ import (
 
 	"github.com/antchfx/xmlquery"
 	"github.com/antchfx/xpath"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -36,6 +39,11 @@ func createCustomEpubFormatError(msg string) *EpubError {
 	return createEpubFormatError(&lastError)
 }
 
+// createEpubWriteError wraps the I/O errors of writing an epub, they are not problems of the format
+func createEpubWriteError(root error) *EpubError {
+	return &EpubError{msg: "Epub write error", root: root}
+}
+
 func (m *EpubError) Unwrap() error {
 	// Return the inner error.
 	return m.root
@@ -43,207 +51,392 @@ func (m *EpubError) Unwrap() error {
 
 type epubMetadata struct {
 	title          string
-	author         []string
-	contributor    []string
+	subtitle       string
+	authors        []eBookData.Person
+	contributors   []eBookData.Person
+	nameStyle      eBookData.AuthorNameStyle
 	isbn           string
 	publisher      string
-	publishingDate string
+	publishingDate eBookData.Date
+	language       string
+	description    string
+	subjects       []string
+	series         string
+	seriesIndex    float64
+	collections    []eBookData.Collection
+	calibre        calibreData
+	rights         string
+	identifiers    []eBookData.Identifier
+	modified       eBookData.Date
 }
 
 func (e epubMetadata) Author() []string {
-	return e.author
+	return eBookData.Names(e.authors, e.nameStyle)
+}
+func (e epubMetadata) Authors() []eBookData.Person {
+	return e.authors
 }
 func (e epubMetadata) Title() string {
 	return e.title
 }
+func (e epubMetadata) Subtitle() string {
+	return e.subtitle
+}
 func (e epubMetadata) Publisher() string {
 	return e.publisher
 }
 func (e epubMetadata) PubDate() string {
+	return e.publishingDate.YearString()
+}
+func (e epubMetadata) PublishedDate() eBookData.Date {
 	return e.publishingDate
 }
 func (e epubMetadata) ISBN() string {
 	return e.isbn
 }
 func (e epubMetadata) Contributor() []string {
-	return e.contributor
+	return eBookData.Names(e.contributors, eBookData.AUTHOR_NAME_DISPLAY)
+}
+func (e epubMetadata) Contributors() []eBookData.Person {
+	return e.contributors
+}
+func (e epubMetadata) Language() string {
+	return e.language
+}
+func (e epubMetadata) Description() string {
+	return e.description
+}
+func (e epubMetadata) Subjects() []string {
+	return e.subjects
+}
+func (e epubMetadata) Series() string {
+	return e.series
+}
+func (e epubMetadata) SeriesIndex() float64 {
+	return e.seriesIndex
+}
+func (e epubMetadata) Collections() []eBookData.Collection {
+	return e.collections
+}
+func (e epubMetadata) SortTitle() string {
+	return e.calibre.titleSort
+}
+func (e epubMetadata) Rating() float64 {
+	return e.calibre.rating
+}
+func (e epubMetadata) Added() eBookData.Date {
+	return e.calibre.timestamp
+}
+func (e epubMetadata) Custom() map[string]any {
+	return e.calibre.custom
+}
+func (e epubMetadata) UserCategories() map[string][]string {
+	return e.calibre.userCategories
+}
+func (e epubMetadata) Rights() string {
+	return e.rights
+}
+func (e epubMetadata) Identifiers() []eBookData.Identifier {
+	return e.identifiers
+}
+func (e epubMetadata) Modified() eBookData.Date {
+	return e.modified
 }
 
 type Epub struct {
-	metadata epubMetadata
-	cover    []byte
+	metadata   epubMetadata
+	cover      *eBookData.Cover
+	renditions []eBookData.Rendition
+	rendition  eBookData.Rendition
+	toc        *lazyToc
+	spine      []SpineItem
+	mediaTypes map[string]string
+	encryption eBookData.Encryption
+	zip        *zip.Reader
+	files      map[string]*zip.File
+	closer     io.Closer
+	logger     *zerolog.Logger
 }
 
-func parseTitle(doc *xmlquery.Node, nsMap map[string]string) (string, error) {
-	expr, _ := xpath.CompileWithNS("/opf:package/opf:metadata/dc:title/text()", nsMap)
-	nodes := xmlquery.QuerySelectorAll(doc, expr)
-	for _, node := range nodes {
-		title := node.InnerText()
-		log.Logger.Trace().Str("Title", title).Msg("Title parsed")
-		return title, nil
+func (epub Epub) log() *zerolog.Logger {
+	if epub.logger == nil {
+		return &log.Logger
 	}
-	log.Logger.Trace().Msg("No title found")
-	return "", nil
-}
-func parseIsbn(doc *xmlquery.Node, nsMap map[string]string) (string, error) {
-	expr, _ := xpath.CompileWithNS("/opf:package/opf:metadata/dc:identifier[@id='ISBN']/text()", nsMap)
-	nodes := xmlquery.QuerySelectorAll(doc, expr)
-	for _, node := range nodes {
-		isbn := node.InnerText()
-		log.Logger.Trace().Str("ISBN", isbn).Msg("ISBN parsed")
-		return isbn, nil
-	}
-	log.Logger.Trace().Msg("No ISBN found")
-	return "", nil
+	return epub.logger
 }
 
-func parsePublisher(doc *xmlquery.Node, nsMap map[string]string) (string, error) {
+func parsePublisher(doc *xmlquery.Node, nsMap map[string]string, logger *zerolog.Logger) (string, error) {
 	expr, _ := xpath.CompileWithNS("/opf:package/opf:metadata/dc:publisher/text()", nsMap)
 	nodes := xmlquery.QuerySelectorAll(doc, expr)
 	for _, node := range nodes {
 		publisher := node.InnerText()
-		log.Logger.Trace().Str("Publisher", publisher).Msg("Publisher parsed")
+		logger.Trace().Str("Publisher", publisher).Msg("Publisher parsed")
 		return publisher, nil
 	}
-	log.Logger.Trace().Msg("No publisher found")
+	logger.Trace().Msg("No publisher found")
 	return "", nil
 }
-func parsePubDate(doc *xmlquery.Node, nsMap map[string]string) (string, error) {
-	expr, _ := xpath.CompileWithNS("/opf:package/opf:metadata/dc:date/text()", nsMap)
+
+// parsePubDate reads the dc:date of the publication, a dc:date with other opf:event (eg. creation) is used only if there is no other
+func parsePubDate(doc *xmlquery.Node, nsMap map[string]string, logger *zerolog.Logger) (eBookData.Date, error) {
+	expr, _ := xpath.CompileWithNS("/opf:package/opf:metadata/dc:date", nsMap)
 	nodes := xmlquery.QuerySelectorAll(doc, expr)
+	var selected *xmlquery.Node
 	for _, node := range nodes {
-		pubDate := node.InnerText()
-		if len(pubDate) > 4 {
-			pubDate = pubDate[:4]
+		event := strings.ToLower(node.SelectAttr("opf:event"))
+		if event == "modification" {
+			continue
+		}
+		if event == "publication" {
+			selected = node
+			break
+		}
+		if selected == nil || selected.SelectAttr("opf:event") != "" {
+			selected = node
 		}
-		log.Logger.Trace().Str("PubDate", pubDate).Msg("PubDate parsed")
-		return pubDate, nil
 	}
-	log.Logger.Trace().Msg("No puDate found")
-	return "", nil
-}
-func parseCoverFile(doc *xmlquery.Node, nsMap map[string]string) (string, error) {
-	expr, _ := xpath.CompileWithNS("/opf:package/opf:metadata/meta[@name='cover']/@content", nsMap)
-	nodes := xmlquery.QuerySelectorAll(doc, expr)
-	var coverId string
-	for _, node := range nodes {
-		coverId = node.InnerText()
-		log.Logger.Trace().Str("CoverId", coverId).Msg("CoverId parsed")
-		break
+	if selected == nil {
+		logger.Trace().Msg("No puDate found")
+		return eBookData.Date{}, nil
 	}
-	if coverId == "" {
-		log.Logger.Trace().Msg("No CoverId found")
-		return "", nil
+	pubDate, err := eBookData.ParseDate(selected.InnerText())
+	if err != nil {
+		logger.Trace().Err(err).Msg("Invalid pubDate")
+		return eBookData.Date{}, nil
 	}
-	expr, _ = xpath.CompileWithNS("/opf:package/opf:manifest/item[@id='"+coverId+"']/@href", nsMap)
-	nodes = xmlquery.QuerySelectorAll(doc, expr)
-	for _, node := range nodes {
-		cover := node.InnerText()
-		log.Logger.Trace().Str("Cover", cover).Msg("Cover parsed")
-		return cover, nil
+	logger.Trace().Str("PubDate", pubDate.String()).Msg("PubDate parsed")
+	return pubDate, nil
+}
+func parseFirstText(doc *xmlquery.Node, nsMap map[string]string, query string, name string, logger *zerolog.Logger) string {
+	expr, err := xpath.CompileWithNS(query, nsMap)
+	if err != nil {
+		panic(err)
 	}
-	log.Logger.Trace().Msg("No Cover found")
-	return "", nil
+	for _, node := range xmlquery.QuerySelectorAll(doc, expr) {
+		value := strings.TrimSpace(node.InnerText())
+		if value == "" {
+			continue
+		}
+		logger.Trace().Str(name, value).Msg(name + " parsed")
+		return value
+	}
+	logger.Trace().Msg("No " + name + " found")
+	return ""
 }
-
-func parseAuthor(doc *xmlquery.Node, nsMap map[string]string, mode string) ([]string, error) {
+func parseAllText(doc *xmlquery.Node, nsMap map[string]string, query string, name string, logger *zerolog.Logger) []string {
 	ret := make([]string, 0)
-	expr, err := xpath.CompileWithNS("/opf:package/opf:metadata/dc:creator[@opf:role='aut' or not(@opf:role)]", nsMap)
+	expr, err := xpath.CompileWithNS(query, nsMap)
 	if err != nil {
 		panic(err)
 	}
-	nodes := xmlquery.QuerySelectorAll(doc, expr)
-	for _, node := range nodes {
-		author := ""
-		if mode == "file-as" {
-			author = node.SelectAttr("opf:file-as")
-			if author == "" {
-				author = node.InnerText()
-			}
-		} else {
-			author = node.InnerText()
+	for _, node := range xmlquery.QuerySelectorAll(doc, expr) {
+		value := strings.TrimSpace(node.InnerText())
+		if value == "" {
+			continue
 		}
-		log.Logger.Trace().Str("Author", author).Msg("Author parsed")
-		ret = append(ret, author)
+		logger.Trace().Str(name, value).Msg(name + " parsed")
+		ret = append(ret, value)
 	}
-	log.Logger.Trace().Int("Author qrt", len(ret)).Msg("All authors parsed")
-	return ret, nil
+	logger.Trace().Int(name+" qrt", len(ret)).Msg("All " + name + " parsed")
+	return ret
 }
-func parseContributor(doc *xmlquery.Node, nsMap map[string]string) ([]string, error) {
-	ret := make([]string, 0)
-	expr, err := xpath.CompileWithNS("/opf:package/opf:metadata/dc:contributor", nsMap)
+func parseLanguage(doc *xmlquery.Node, nsMap map[string]string, logger *zerolog.Logger) (string, error) {
+	return parseFirstText(doc, nsMap, "/opf:package/opf:metadata/dc:language", "Language", logger), nil
+}
+func parseDescription(doc *xmlquery.Node, nsMap map[string]string, logger *zerolog.Logger) (string, error) {
+	return parseFirstText(doc, nsMap, "/opf:package/opf:metadata/dc:description", "Description", logger), nil
+}
+func parseSubjects(doc *xmlquery.Node, nsMap map[string]string, logger *zerolog.Logger) ([]string, error) {
+	return parseAllText(doc, nsMap, "/opf:package/opf:metadata/dc:subject", "Subject", logger), nil
+}
+func parseRights(doc *xmlquery.Node, nsMap map[string]string, logger *zerolog.Logger) (string, error) {
+	return parseFirstText(doc, nsMap, "/opf:package/opf:metadata/dc:rights", "Rights", logger), nil
+}
+
+// parseModified reads the EPUB3 dcterms:modified meta, or the EPUB2 modification date
+func parseModified(doc *xmlquery.Node, nsMap map[string]string, logger *zerolog.Logger) (eBookData.Date, error) {
+	modified := parseFirstText(doc, nsMap, "/opf:package/opf:metadata/opf:meta[@property='dcterms:modified']", "Modified", logger)
+	if modified == "" {
+		modified = parseFirstText(doc, nsMap, "/opf:package/opf:metadata/dc:date[@opf:event='modification']", "Modified", logger)
+	}
+	date, err := eBookData.ParseDate(modified)
+	if err != nil {
+		logger.Trace().Err(err).Msg("Invalid modification date")
+		return eBookData.Date{}, nil
+	}
+	return date, nil
+}
+
+// identifierScheme returns the scheme and the value of a dc:identifier, see parseIdentifiers. The scheme is empty if it is unknown.
+func identifierScheme(node *xmlquery.Node, refines refinements) (string, string) {
+	value := strings.TrimSpace(node.InnerText())
+	id := node.SelectAttr("id")
+	detected, stripped := eBookData.DetectScheme(value)
+	var scheme string
+	switch {
+	case node.SelectAttr("opf:scheme") != "":
+		scheme = eBookData.NormalizeScheme(node.SelectAttr("opf:scheme"))
+	case refines.first(id, "identifier-type") != "":
+		scheme = eBookData.NormalizeScheme(refines.first(id, "identifier-type"))
+	case detected != "":
+		scheme = detected
+	case eBookData.IsKnownScheme(id):
+		scheme = eBookData.NormalizeScheme(id)
+	}
+	if detected == scheme {
+		value = stripped
+	}
+	return scheme, value
+}
+
+// parseIdentifiers reads every dc:identifier in document order with the normalized scheme.
+// The scheme comes from the opf:scheme attribute, the EPUB3 identifier-type refinement, the value (a prefix like urn:isbn:,
+// a valid ISBN or a UUID) or an id that is a scheme name (eg. id="isbn"), in this order. The prefix of the value is removed
+// if it is the same scheme. The identifiers without a known scheme are kept with an empty scheme.
+func parseIdentifiers(doc *xmlquery.Node, nsMap map[string]string, refines refinements, logger *zerolog.Logger) ([]eBookData.Identifier, error) {
+	ret := make([]eBookData.Identifier, 0)
+	expr, err := xpath.CompileWithNS("/opf:package/opf:metadata/dc:identifier", nsMap)
 	if err != nil {
 		panic(err)
 	}
-	nodes := xmlquery.QuerySelectorAll(doc, expr)
-	for _, node := range nodes {
-		contributor := node.InnerText()
-		log.Logger.Trace().Str("Contributor", contributor).Msg("Contributor parsed")
-		ret = append(ret, contributor)
+	for _, node := range xmlquery.QuerySelectorAll(doc, expr) {
+		scheme, value := identifierScheme(node, refines)
+		logger.Trace().Str("Scheme", scheme).Str("Identifier", value).Msg("Identifier parsed")
+		ret = eBookData.AppendIdentifier(ret, scheme, value)
 	}
-	log.Logger.Trace().Int("Contributor qrt", len(ret)).Msg("All contributors parsed")
+	logger.Trace().Int("Identifier qrt", len(ret)).Msg("All identifiers parsed")
 	return ret, nil
 }
-func parseMetadata(file *zip.File, mode string) (*epubMetadata, string, error) {
-	metadata := &epubMetadata{}
+
+// parsePersons returns the persons of the elements, the ones without role get defaultRole (if it is not empty)
+func parsePersons(doc *xmlquery.Node, nsMap map[string]string, refines refinements, query string, defaultRole string, logger *zerolog.Logger) []eBookData.Person {
+	ret := make([]eBookData.Person, 0)
+	expr, err := xpath.CompileWithNS(query, nsMap)
+	if err != nil {
+		panic(err)
+	}
+	for _, node := range xmlquery.QuerySelectorAll(doc, expr) {
+		person := eBookData.Person{Name: strings.TrimSpace(node.InnerText())}
+		if person.Name == "" {
+			continue
+		}
+		id := node.SelectAttr("id")
+		person.FileAs = strings.TrimSpace(node.SelectAttr("opf:file-as"))
+		if person.FileAs == "" {
+			person.FileAs = refines.first(id, "file-as")
+		}
+		roles := append([]string{node.SelectAttr("opf:role")}, refines.get(id, "role")...)
+		for _, role := range roles {
+			role = strings.ToLower(strings.TrimSpace(role))
+			if role != "" && !person.HasRole(role) {
+				person.Roles = append(person.Roles, role)
+			}
+		}
+		if len(person.Roles) == 0 && defaultRole != "" {
+			person.Roles = []string{defaultRole}
+		}
+		logger.Trace().Str("Name", person.Name).Str("FileAs", person.FileAs).Strs("Roles", person.Roles).Msg("Person parsed")
+		ret = append(ret, person)
+	}
+	return ret
+}
+
+// parseCreators returns the authors and the contributors. The authors are the dc:creator elements with author role,
+// the ones without role get the author role. The contributors are the dc:contributor elements and the dc:creator elements with other roles.
+func parseCreators(doc *xmlquery.Node, nsMap map[string]string, refines refinements, logger *zerolog.Logger) ([]eBookData.Person, []eBookData.Person, error) {
+	authors := make([]eBookData.Person, 0)
+	contributors := parsePersons(doc, nsMap, refines, "/opf:package/opf:metadata/dc:contributor", eBookData.ROLE_CONTRIBUTOR, logger)
+	for _, creator := range parsePersons(doc, nsMap, refines, "/opf:package/opf:metadata/dc:creator", eBookData.ROLE_AUTHOR, logger) {
+		if creator.IsAuthor() {
+			authors = append(authors, creator)
+		} else {
+			contributors = append(contributors, creator)
+		}
+	}
+	logger.Trace().Int("Author qrt", len(authors)).Msg("All authors parsed")
+	logger.Trace().Int("Contributor qrt", len(contributors)).Msg("All contributors parsed")
+	return authors, contributors, nil
+}
+
+var opfNsMap = map[string]string{
+	"dc":      "http://purl.org/dc/elements/1.1/",
+	"dcterms": "http://purl.org/dc/terms/",
+	"opf":     "http://www.idpf.org/2007/opf",
+}
+
+func readXml(file *zip.File) (*xmlquery.Node, error) {
 	reader, err := file.Open()
 	if err != nil {
-		return metadata, "", createCustomEpubFormatError("Root file not readable")
+		return nil, createCustomEpubFormatError(file.Name + " not readable")
 	}
+	defer reader.Close()
 	doc, err := xmlquery.Parse(reader)
 	if err != nil {
-		return metadata, "", createEpubFormatError(err)
+		return nil, createEpubFormatError(err)
 	}
-	nsMap := map[string]string{
-		"dc":      "http://purl.org/dc/elements/1.1/",
-		"dcterms": "http://purl.org/dc/terms/",
-		"opf":     "http://www.idpf.org/2007/opf",
+	return doc, nil
+}
+
+func parseMetadata(doc *xmlquery.Node, opts *eBookData.ParseOptions) (*epubMetadata, error) {
+	logger := opts.Log()
+	metadata := &epubMetadata{nameStyle: opts.GetAuthorName()}
+	nsMap := opfNsMap
+	var err error
+	refines, err := parseRefines(doc, nsMap, logger)
+	if err != nil {
+		return metadata, createEpubFormatError(err)
 	}
-	if metadata.title, err = parseTitle(doc, nsMap); err != nil {
-		return metadata, "", createEpubFormatError(err)
+	if metadata.title, metadata.subtitle, err = parseTitle(doc, nsMap, refines, logger); err != nil {
+		return metadata, createEpubFormatError(err)
 	}
-	if metadata.author, err = parseAuthor(doc, nsMap, mode); err != nil {
-		return metadata, "", createEpubFormatError(err)
+	if metadata.title == "" && opts.IsStrict() {
+		return metadata, createCustomEpubFormatError("No title in the metadata")
 	}
-	if metadata.contributor, err = parseContributor(doc, nsMap); err != nil {
-		return metadata, "", createEpubFormatError(err)
+	if metadata.authors, metadata.contributors, err = parseCreators(doc, nsMap, refines, logger); err != nil {
+		return metadata, createEpubFormatError(err)
 	}
-	if metadata.isbn, err = parseIsbn(doc, nsMap); err != nil {
-		return metadata, "", createEpubFormatError(err)
+	if metadata.publisher, err = parsePublisher(doc, nsMap, logger); err != nil {
+		return metadata, createEpubFormatError(err)
 	}
-	if metadata.publisher, err = parsePublisher(doc, nsMap); err != nil {
-		return metadata, "", createEpubFormatError(err)
+	if metadata.publishingDate, err = parsePubDate(doc, nsMap, logger); err != nil {
+		return metadata, createEpubFormatError(err)
 	}
-	if metadata.publishingDate, err = parsePubDate(doc, nsMap); err != nil {
-		return metadata, "", createEpubFormatError(err)
+	if metadata.language, err = parseLanguage(doc, nsMap, logger); err != nil {
+		return metadata, createEpubFormatError(err)
 	}
-	var cover string
-	if cover, err = parseCoverFile(doc, nsMap); err != nil {
-		return metadata, "", createEpubFormatError(err)
+	if metadata.description, err = parseDescription(doc, nsMap, logger); err != nil {
+		return metadata, createEpubFormatError(err)
 	}
-	return metadata, cover, nil
-}
-
-func getRoot(file *zip.File) (string, error) {
-	reader, err := file.Open()
-	if err != nil {
-		return "", createCustomEpubFormatError("Root file not readable")
+	if metadata.subjects, err = parseSubjects(doc, nsMap, logger); err != nil {
+		return metadata, createEpubFormatError(err)
 	}
-	doc, err := xmlquery.Parse(reader)
-	if err != nil {
-		return "", createEpubFormatError(err)
+	if metadata.collections, err = parseCollections(doc, nsMap, refines, logger); err != nil {
+		return metadata, createEpubFormatError(err)
 	}
-	nsMap := map[string]string{
-		"a": "urn:oasis:names:tc:opendocument:xmlns:container",
+	if metadata.series, metadata.seriesIndex, err = parseSeries(doc, nsMap, logger); err != nil {
+		return metadata, createEpubFormatError(err)
 	}
-	//	expr, _ := xpath.CompileWithNS("/a:container/a:rootfiles/a:rootfile/@full-path", nsMap)
-	expr, _ := xpath.CompileWithNS("/container/rootfiles/rootfile/@full-path", nsMap)
-	nodes := xmlquery.QuerySelectorAll(doc, expr)
-	for _, node := range nodes {
-		return node.InnerText(), nil
+	if metadata.calibre, err = parseCalibre(doc, nsMap, logger); err != nil {
+		return metadata, createEpubFormatError(err)
 	}
-	return "", nil
+	// the EPUB3 series collection is preferred over the calibre metas
+	if series, ok := seriesCollection(metadata.collections); ok {
+		metadata.series, metadata.seriesIndex = series.Name, series.Position
+	}
+	if metadata.rights, err = parseRights(doc, nsMap, logger); err != nil {
+		return metadata, createEpubFormatError(err)
+	}
+	if metadata.identifiers, err = parseIdentifiers(doc, nsMap, refines, logger); err != nil {
+		return metadata, createEpubFormatError(err)
+	}
+	metadata.identifiers = opts.NormalizeISBNs(metadata.identifiers)
+	metadata.isbn = eBookData.FindIdentifier(metadata.identifiers, eBookData.SCHEME_ISBN)
+	if metadata.modified, err = parseModified(doc, nsMap, logger); err != nil {
+		return metadata, createEpubFormatError(err)
+	}
+	return metadata, nil
 }
+
 func loadCover(file *zip.File) ([]byte, error) {
 	reader, err := file.Open()
 	if err != nil {
@@ -257,19 +450,91 @@ func loadCover(file *zip.File) ([]byte, error) {
 
 }
 
-func ReadEpub(f io.Reader, mode string) (*Epub, error) {
-	log.Logger.Debug().Msg("Start reading epub file")
-	defer log.Logger.Debug().Msg("End reading epub file")
+// readerSize returns the size of a reader that can be read in place: the Size of eg. *bytes.Reader, the Stat of *os.File,
+// or the end of an io.Seeker. The offset of the reader is not changed. ok is false if the size can not be found.
+func readerSize(f io.Reader) (size int64, ok bool, err error) {
+	switch r := f.(type) {
+	case interface{ Size() int64 }:
+		return r.Size(), true, nil
+	case interface{ Stat() (fs.FileInfo, error) }:
+		stat, err := r.Stat()
+		if err != nil {
+			return 0, true, err
+		}
+		return stat.Size(), true, nil
+	case io.Seeker:
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, true, err
+		}
+		if size, err = r.Seek(0, io.SeekEnd); err != nil {
+			return 0, true, err
+		}
+		_, err = r.Seek(offset, io.SeekStart)
+		return size, true, err
+	}
+	return 0, false, nil
+}
+
+// ReadEpub reads the metadata and the cover of an epub file. opts can be nil for the defaults.
+// If f is an io.ReaderAt with a known size (eg. *os.File, *bytes.Reader or an io.Seeker), it is read in place
+// without changing its offset, otherwise it is copied into memory.
+func ReadEpub(f io.Reader, opts *eBookData.ParseOptions) (*Epub, error) {
+	if ra, ok := f.(io.ReaderAt); ok {
+		size, ok, err := readerSize(f)
+		if err != nil {
+			return nil, createEpubFormatError(err)
+		}
+		if ok {
+			return ReadEpubAt(ra, size, opts)
+		}
+	}
 	buff := bytes.NewBuffer([]byte{})
+	if opts != nil && opts.MaxFileSize > 0 {
+		// one byte more to detect the oversized files
+		f = io.LimitReader(f, opts.MaxFileSize+1)
+	}
 	size, err := io.Copy(buff, f)
 	if err != nil {
 		return nil, createEpubFormatError(err)
 	}
+	return ReadEpubAt(bytes.NewReader(buff.Bytes()), size, opts)
+}
 
-	reader := bytes.NewReader(buff.Bytes())
+// OpenEpub reads the epub file at path and keeps it open, so the content of the book stays accessible.
+// The returned Epub has to be closed.
+func OpenEpub(path string, opts *eBookData.ParseOptions) (*Epub, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, createEpubFormatError(err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, createEpubFormatError(err)
+	}
+	epub, err := ReadEpubAt(f, stat.Size(), opts)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	epub.closer = f
+	return epub, nil
+}
+
+// ReadEpubAt reads the metadata and the cover of an epub from r. Only the zip central directory,
+// the container, the OPF and the files needed to find the cover are read, the table of contents is read on its first use.
+// r has to stay readable while the content of the book is used.
+func ReadEpubAt(r io.ReaderAt, size int64, opts *eBookData.ParseOptions) (*Epub, error) {
+	logger := opts.Log()
+	logger.Debug().Msg("Start reading epub file")
+	defer logger.Debug().Msg("End reading epub file")
+	if !opts.FileSizeAllowed(size) {
+		return nil, createCustomEpubFormatError("Epub file too large")
+	}
 
 	// Open a zip archive for reading.
-	zipReader, err := zip.NewReader(reader, size)
+	zipReader, err := zip.NewReader(r, size)
 	if err != nil {
 		return nil, createEpubFormatError(err)
 	}
@@ -279,56 +544,148 @@ func ReadEpub(f io.Reader, mode string) (*Epub, error) {
 		files[file.Name] = file
 	}
 	fileList := slices.Collect(maps.Keys(files))
-	log.Logger.Trace().Strs("files", fileList).Msg("Files in the zip")
+	logger.Trace().Strs("files", fileList).Msg("Files in the zip")
 
-	metainfFile := files["META-INF/container.xml"]
+	metainfFile := files[CONTAINER_PATH]
 	if metainfFile == nil {
 		return nil, createCustomEpubFormatError("No META-INF/container file")
 	}
-	rootFile, err := getRoot(metainfFile)
+	renditions, err := parseContainer(metainfFile, logger)
 	if err != nil {
 		return nil, createEpubFormatError(err)
 	}
-	if rootFile == "" {
+	if len(renditions) == 0 {
 		return nil, createCustomEpubFormatError("Invalid META-INF/container file")
 	}
+	rendition := selectRendition(renditions, opts)
+	logger.Trace().Str("rootfile", rendition.FullPath).Int("renditions", len(renditions)).Msg("Rendition selected")
+	rootFile := rendition.FullPath
 	contentFile := files[rootFile]
 	if contentFile == nil {
 		return nil, createCustomEpubFormatError("No content.opf file")
 	}
-	//metadata, coverFile, err := getMetadata(contentFile)
-	metadata, cover, err := parseMetadata(contentFile, mode)
+	doc, err := readXml(contentFile)
+	if err != nil {
+		return nil, createEpubFormatError(err)
+	}
+	metadata, err := parseMetadata(doc, opts)
 	if err != nil {
 		return nil, createEpubFormatError(err)
 	}
-	coverData := make([]byte, 0)
-	if cover != "" {
-		// first try
-		coverFile := files[cover]
-		if coverFile == nil {
-			for _, f := range fileList {
-				if strings.HasSuffix(f, cover) {
-					coverFile = files[f]
-					break
-				}
+	encryption, err := parseEncryption(files, logger)
+	if err != nil {
+		if opts.IsStrict() {
+			return nil, createEpubFormatError(err)
+		}
+		logger.Warn().Err(err).Msg("Encryption not readable")
+	}
+	if encryption.IsDRM() {
+		logger.Debug().Str("scheme", string(encryption.Scheme)).Int("resources", len(encryption.Resources)).Msg("Epub is protected by DRM")
+	}
+	var (
+		coverData *eBookData.Cover
+		cover     coverRef
+	)
+	if opts.LoadCover() {
+		if cover, err = findCover(doc, opfNsMap, rootFile, files, logger); err != nil {
+			if opts.IsStrict() {
+				return nil, createEpubFormatError(err)
 			}
+			logger.Warn().Err(err).Msg("Cover definition not readable")
 		}
-		if coverFile != nil {
-			coverData, err = loadCover(coverFile)
+	}
+	if cover.href != "" {
+		coverFile := files[cover.href]
+		switch {
+		case coverFile == nil:
+			if opts.IsStrict() {
+				return nil, createCustomEpubFormatError("No cover file, but defined")
+			}
+			logger.Warn().Msg("No cover file, but defined")
+		case !opts.CoverSizeAllowed(int64(coverFile.UncompressedSize64)):
+			if opts.IsStrict() {
+				return nil, createCustomEpubFormatError("Cover file too large")
+			}
+			logger.Warn().Uint64("size", coverFile.UncompressedSize64).Msg("Cover file too large, skipped")
+		case encryption.IsEncrypted(coverFile.Name):
+			logger.Warn().Str("scheme", string(encryption.Scheme)).Msg("Cover file encrypted, skipped")
+		default:
+			data, err := loadCover(coverFile)
 			if err != nil {
 				return nil, createEpubFormatError(err)
 			}
-		} else {
-			log.Logger.Warn().Msg("No cover file, but defined")
+			coverData = eBookData.NewCover(data, cover.mediaType, cover.source)
+			coverData.Path = coverFile.Name
 		}
 	}
 
-	ret := Epub{metadata: *metadata, cover: coverData}
+	toc := &lazyToc{load: func() []eBookData.TOCEntry {
+		entries, err := parseToc(doc, opfNsMap, rootFile, files, logger)
+		if err != nil {
+			logger.Warn().Err(err).Msg("Table of contents not readable")
+		}
+		return entries
+	}}
+	if opts.IsStrict() {
+		// strict mode reports the problems of the table of contents on read
+		entries, err := parseToc(doc, opfNsMap, rootFile, files, logger)
+		if err != nil {
+			return nil, createEpubFormatError(err)
+		}
+		toc = &lazyToc{entries: entries}
+	}
+
+	manifest := parseManifest(doc, opfNsMap, logger)
+	ret := Epub{
+		metadata:   *metadata,
+		toc:        toc,
+		spine:      parseSpineItems(doc, opfNsMap, rootFile, manifest, logger),
+		mediaTypes: manifestMediaTypes(manifest, rootFile),
+		encryption: encryption,
+		cover:      coverData,
+		renditions: renditions,
+		rendition:  rendition,
+		zip:        zipReader,
+		files:      files,
+		logger:     logger,
+	}
 	return &ret, nil
 }
 func (epub Epub) Metadata() eBookData.Metadata {
 	return epub.metadata
 }
-func (epub Epub) Cover() []byte {
+func (epub Epub) Cover() *eBookData.Cover {
 	return epub.cover
 }
+
+// Renditions returns every package rendition listed in the container, the first one is the default
+func (epub Epub) Renditions() []eBookData.Rendition {
+	return epub.renditions
+}
+
+// Rendition returns the rendition that was parsed
+func (epub Epub) Rendition() eBookData.Rendition {
+	return epub.rendition
+}
+
+// Close closes the file opened by OpenEpub, it does nothing for the other readers
+func (epub *Epub) Close() error {
+	if epub.closer == nil {
+		return nil
+	}
+	err := epub.closer.Close()
+	epub.closer = nil
+	return err
+}
+
+// Encryption returns the DRM scheme and the encrypted files of the epub, read from META-INF/encryption.xml and rights.xml.
+// OpenItem and FS return the encrypted files as they are stored.
+func (epub Epub) Encryption() eBookData.Encryption {
+	return epub.encryption
+}
+
+// TableOfContents returns the EPUB3 navigation or the EPUB2 NCX table of contents.
+// It is parsed on the first call (in strict mode on read), so the reader of the epub has to be still open.
+func (epub Epub) TableOfContents() []eBookData.TOCEntry {
+	return epub.toc.get()
+}
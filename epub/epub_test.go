@@ -3,11 +3,26 @@ package epub
 import (
 	"archive/zip"
 	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
 	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/fstest"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
 
 	"github.com/antchfx/xmlquery"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 )
 
 // Helper: create an XML document from string
@@ -28,7 +43,7 @@ func TestParseTitle(t *testing.T) {
 	doc := parseXML(t, xml)
 	nsMap := map[string]string{"dc": "http://purl.org/dc/elements/1.1/", "opf": "http://www.idpf.org/2007/opf"}
 
-	title, err := parseTitle(doc, nsMap)
+	title, _, err := parseTitle(doc, nsMap, refinements{}, &log.Logger)
 	if err != nil || title != "Test Book Title" {
 		t.Errorf("Expected title 'Test Book Title', got '%s' (err: %v)", title, err)
 	}
@@ -43,8 +58,8 @@ func TestParseAuthor(t *testing.T) {
 	doc := parseXML(t, xml)
 	nsMap := map[string]string{"dc": "http://purl.org/dc/elements/1.1/", "opf": "http://www.idpf.org/2007/opf"}
 
-	authors, err := parseAuthor(doc, nsMap, "normal")
-	if err != nil || len(authors) != 1 || authors[0] != "Jane Smith" {
+	authors, _, err := parseCreators(doc, nsMap, refinements{}, &log.Logger)
+	if err != nil || len(authors) != 1 || authors[0].Name != "Jane Smith" || !authors[0].HasRole("aut") {
 		t.Errorf("Expected author 'Jane Smith', got %v (err: %v)", authors, err)
 	}
 }
@@ -58,8 +73,8 @@ func TestParseContributor(t *testing.T) {
 	doc := parseXML(t, xml)
 	nsMap := map[string]string{"dc": "http://purl.org/dc/elements/1.1/", "opf": "http://www.idpf.org/2007/opf"}
 
-	contributors, err := parseContributor(doc, nsMap)
-	if err != nil || len(contributors) != 1 || contributors[0] != "Editor One" {
+	_, contributors, err := parseCreators(doc, nsMap, refinements{}, &log.Logger)
+	if err != nil || len(contributors) != 1 || contributors[0].Name != "Editor One" {
 		t.Errorf("Expected contributor 'Editor One', got %v (err: %v)", contributors, err)
 	}
 }
@@ -73,12 +88,43 @@ func TestParseIsbn(t *testing.T) {
 	doc := parseXML(t, xml)
 	nsMap := map[string]string{"dc": "http://purl.org/dc/elements/1.1/", "opf": "http://www.idpf.org/2007/opf"}
 
-	isbn, err := parseIsbn(doc, nsMap)
-	if err != nil || isbn != "1234567890" {
+	identifiers, err := parseIdentifiers(doc, nsMap, refinements{}, &log.Logger)
+	if isbn := eBookData.FindIdentifier(identifiers, "ISBN"); err != nil || isbn != "1234567890" {
 		t.Errorf("Expected ISBN '1234567890', got '%s' (err: %v)", isbn, err)
 	}
 }
 
+func TestParseIdentifierFromValue(t *testing.T) {
+	xml := `<package xmlns="http://www.idpf.org/2007/opf">
+		<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+			<dc:identifier id="pub-id">9780306406157</dc:identifier>
+			<dc:identifier id="book-id">0f0e0d0c-1a2b-3c4d-5e6f-7a8b9c0d1e2f</dc:identifier>
+			<dc:identifier id="other">some-local-id</dc:identifier>
+			<dc:identifier>no id at all</dc:identifier>
+		</metadata>
+	</package>`
+	doc := parseXML(t, xml)
+	nsMap := map[string]string{"dc": "http://purl.org/dc/elements/1.1/", "opf": "http://www.idpf.org/2007/opf"}
+
+	identifiers, err := parseIdentifiers(doc, nsMap, refinements{}, &log.Logger)
+	if err != nil {
+		t.Fatalf("parseIdentifiers failed: %v", err)
+	}
+	expected := []eBookData.Identifier{
+		{Scheme: "ISBN", Value: "9780306406157"},
+		{Scheme: "UUID", Value: "0f0e0d0c-1a2b-3c4d-5e6f-7a8b9c0d1e2f"},
+		{Scheme: "", Value: "some-local-id"},
+		{Scheme: "", Value: "no id at all"},
+	}
+	if !slices.Equal(identifiers, expected) {
+		t.Errorf("Expected %v, got %v", expected, identifiers)
+	}
+	metadata, err := parseMetadata(doc, nil)
+	if err != nil || metadata.ISBN() != "9780306406157" {
+		t.Errorf("Expected ISBN from the value, got %q (err: %v)", metadata.ISBN(), err)
+	}
+}
+
 func TestParsePublisher(t *testing.T) {
 	xml := `<package xmlns="http://www.idpf.org/2007/opf">
 		<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
@@ -88,7 +134,7 @@ func TestParsePublisher(t *testing.T) {
 	doc := parseXML(t, xml)
 	nsMap := map[string]string{"dc": "http://purl.org/dc/elements/1.1/", "opf": "http://www.idpf.org/2007/opf"}
 
-	publisher, err := parsePublisher(doc, nsMap)
+	publisher, err := parsePublisher(doc, nsMap, &log.Logger)
 	if err != nil || publisher != "GoLang Books" {
 		t.Errorf("Expected publisher 'GoLang Books', got '%s' (err: %v)", publisher, err)
 	}
@@ -103,9 +149,9 @@ func TestParsePubDate(t *testing.T) {
 	doc := parseXML(t, xml)
 	nsMap := map[string]string{"dc": "http://purl.org/dc/elements/1.1/", "opf": "http://www.idpf.org/2007/opf"}
 
-	date, err := parsePubDate(doc, nsMap)
-	if err != nil || date != "2022" {
-		t.Errorf("Expected pub date '2022', got '%s' (err: %v)", date, err)
+	date, err := parsePubDate(doc, nsMap, &log.Logger)
+	if err != nil || date.YearString() != "2022" || date.Precision != eBookData.DATE_PRECISION_DAY || date.String() != "2022-07-15" {
+		t.Errorf("Expected pub date '2022-07-15', got '%s' (err: %v)", date, err)
 	}
 }
 
@@ -135,7 +181,7 @@ func TestReadEpubMinimal(t *testing.T) {
 
 	zw.Close()
 
-	epub, err := ReadEpub(bytes.NewReader(buf.Bytes()), "normal")
+	epub, err := ReadEpub(bytes.NewReader(buf.Bytes()), nil)
 	if err != nil {
 		t.Fatalf("ReadEpub failed: %v", err)
 	}
@@ -178,11 +224,2060 @@ func TestLoadCover(t *testing.T) {
 
 	zw.Close()
 
-	epub, err := ReadEpub(bytes.NewReader(buf.Bytes()), "normal")
+	epub, err := ReadEpub(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	if epub.cover == nil || !bytes.HasPrefix(epub.cover.Data, []byte{0xFF, 0xD8}) {
+		t.Fatalf("Cover does not start with JPEG header: %+v", epub.cover)
+	}
+	if epub.cover.MediaType != "image/jpeg" || epub.cover.Path != "OEBPS/images/cover.jpg" || epub.cover.Source != eBookData.COVER_SOURCE_META {
+		t.Errorf("Unexpected cover info: %s %s %s", epub.cover.MediaType, epub.cover.Path, epub.cover.Source)
+	}
+}
+
+func TestReadEpubOptions(t *testing.T) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	w, _ := zw.Create("META-INF/container.xml")
+	io.WriteString(w, `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+	<rootfiles>
+		<rootfile full-path="content.opf" media-type="application/oebps-package+xml"/>
+	</rootfiles>
+</container>`)
+
+	w, _ = zw.Create("content.opf")
+	io.WriteString(w, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="BookId">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+		<dc:title>Options</dc:title>
+		<dc:creator opf:role="aut" opf:file-as="Smith, Jane">Jane Smith</dc:creator>
+		<meta name="cover" content="cover-image"/>
+	</metadata>
+	<manifest>
+		<item id="cover-image" href="missing.jpg" media-type="image/jpeg"/>
+	</manifest>
+</package>`)
+	zw.Close()
+
+	epub, err := ReadEpub(bytes.NewReader(buf.Bytes()), &eBookData.ParseOptions{AuthorName: eBookData.AUTHOR_NAME_FILE_AS})
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	if authors := epub.Metadata().Author(); len(authors) != 1 || authors[0] != "Smith, Jane" {
+		t.Errorf("Expected file-as author 'Smith, Jane', got %v", authors)
+	}
+
+	if _, err = ReadEpub(bytes.NewReader(buf.Bytes()), &eBookData.ParseOptions{Strict: true}); err == nil {
+		t.Errorf("Expected error for the missing cover in strict mode")
+	}
+	if _, err = ReadEpub(bytes.NewReader(buf.Bytes()), &eBookData.ParseOptions{MaxFileSize: 10}); err == nil {
+		t.Errorf("Expected error for the file size limit")
+	}
+}
+
+// Helper: create an in-memory epub with the given OPF (at OEBPS/content.opf) and extra files.
+// The default container can be replaced by adding META-INF/container.xml to the files.
+func createEpub(t *testing.T, opf string, files map[string][]byte) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	w, _ := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	io.WriteString(w, "application/epub+zip")
+
+	if _, ok := files["META-INF/container.xml"]; !ok {
+		w, _ = zw.Create("META-INF/container.xml")
+		io.WriteString(w, `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+	<rootfiles>
+		<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+	</rootfiles>
+</container>`)
+	}
+
+	w, _ = zw.Create("OEBPS/content.opf")
+	io.WriteString(w, opf)
+
+	for name, data := range files {
+		w, _ = zw.Create(name)
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to create epub: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadEpubExtendedMetadata(t *testing.T) {
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="BookId" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+		<dc:title>Extended</dc:title>
+		<dc:language>en</dc:language>
+		<dc:description>A short description.</dc:description>
+		<dc:subject>Fiction</dc:subject>
+		<dc:subject>Fantasy</dc:subject>
+		<dc:rights>All rights reserved</dc:rights>
+		<dc:identifier id="BookId" opf:scheme="UUID">urn:uuid:0f0e0d0c</dc:identifier>
+		<meta name="calibre:series" content="The Saga"/>
+		<meta name="calibre:series_index" content="2.5"/>
+		<meta property="dcterms:modified">2023-01-02T03:04:05Z</meta>
+	</metadata>
+</package>`, nil)
+
+	epub, err := ReadEpub(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	meta := epub.Metadata()
+	if meta.Language() != "en" {
+		t.Errorf("Expected language 'en', got '%s'", meta.Language())
+	}
+	if meta.Description() != "A short description." {
+		t.Errorf("Unexpected description '%s'", meta.Description())
+	}
+	if subjects := meta.Subjects(); len(subjects) != 2 || subjects[0] != "Fiction" || subjects[1] != "Fantasy" {
+		t.Errorf("Unexpected subjects %v", subjects)
+	}
+	if meta.Rights() != "All rights reserved" {
+		t.Errorf("Unexpected rights '%s'", meta.Rights())
+	}
+	if meta.Series() != "The Saga" || meta.SeriesIndex() != 2.5 {
+		t.Errorf("Unexpected series '%s' #%v", meta.Series(), meta.SeriesIndex())
+	}
+	if eBookData.FindIdentifier(meta.Identifiers(), "UUID") != "0f0e0d0c" {
+		t.Errorf("Unexpected identifiers %v", meta.Identifiers())
+	}
+	if meta.Modified().String() != "2023-01-02T03:04:05Z" {
+		t.Errorf("Unexpected modification date '%s'", meta.Modified())
+	}
+}
+
+func TestParsePersonRoles(t *testing.T) {
+	xml := `<package xmlns="http://www.idpf.org/2007/opf">
+		<metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+			<dc:creator opf:file-as="Smith, Jane">Jane Smith</dc:creator>
+			<dc:creator opf:role="trl">John Doe</dc:creator>
+			<dc:creator id="ill">Ann Artist</dc:creator>
+			<meta refines="#ill" property="role" scheme="marc:relators">ill</meta>
+			<meta refines="#ill" property="file-as">Artist, Ann</meta>
+			<dc:contributor opf:role="edt">Ed Itor</dc:contributor>
+		</metadata>
+	</package>`
+	doc := parseXML(t, xml)
+	nsMap := map[string]string{"dc": "http://purl.org/dc/elements/1.1/", "opf": "http://www.idpf.org/2007/opf"}
+
+	refines, _ := parseRefines(doc, nsMap, &log.Logger)
+	authors, contributors, _ := parseCreators(doc, nsMap, refines, &log.Logger)
+	if len(authors) != 1 || authors[0].Name != "Jane Smith" || authors[0].SortName() != "Smith, Jane" {
+		t.Errorf("Unexpected authors %+v", authors)
+	}
+	if len(contributors) != 3 {
+		t.Fatalf("Expected 3 contributors, got %+v", contributors)
+	}
+	if contributors[0].Name != "Ed Itor" || !contributors[0].HasRole("edt") {
+		t.Errorf("Unexpected editor %+v", contributors[0])
+	}
+	if contributors[1].Name != "John Doe" || !contributors[1].HasRole("trl") {
+		t.Errorf("Unexpected translator %+v", contributors[1])
+	}
+	if contributors[2].Name != "Ann Artist" || !contributors[2].HasRole("ill") || contributors[2].FileAs != "Artist, Ann" {
+		t.Errorf("Unexpected illustrator %+v", contributors[2])
+	}
+}
+
+func TestParseContributorDefaultRole(t *testing.T) {
+	xml := `<package xmlns="http://www.idpf.org/2007/opf">
+		<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+			<dc:creator>Jane Smith</dc:creator>
+			<dc:contributor>John Doe</dc:contributor>
+		</metadata>
+	</package>`
+	doc := parseXML(t, xml)
+	nsMap := map[string]string{"dc": "http://purl.org/dc/elements/1.1/", "opf": "http://www.idpf.org/2007/opf"}
+
+	out := new(bytes.Buffer)
+	logger := zerolog.New(out).Level(zerolog.TraceLevel)
+	authors, contributors, _ := parseCreators(doc, nsMap, refinements{}, &logger)
+	if len(authors) != 1 || authors[0].Name != "Jane Smith" || !authors[0].HasRole(eBookData.ROLE_AUTHOR) {
+		t.Errorf("The creator without role should get the author role %+v", authors)
+	}
+	if len(contributors) != 1 || contributors[0].IsAuthor() || !contributors[0].HasRole(eBookData.ROLE_CONTRIBUTOR) {
+		t.Errorf("The contributor without role should not be an author %+v", contributors)
+	}
+	if count := strings.Count(out.String(), "Person parsed"); count != 2 {
+		t.Errorf("Every person should be logged once, got %d\n%s", count, out)
+	}
+}
+
+func TestParsePubDateEvents(t *testing.T) {
+	xml := `<package xmlns="http://www.idpf.org/2007/opf">
+		<metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+			<dc:date opf:event="modification">2021-01-01</dc:date>
+			<dc:date opf:event="creation">2019-05-05</dc:date>
+			<dc:date opf:event="publication">2020-03</dc:date>
+		</metadata>
+	</package>`
+	doc := parseXML(t, xml)
+	nsMap := map[string]string{"dc": "http://purl.org/dc/elements/1.1/", "opf": "http://www.idpf.org/2007/opf"}
+
+	date, err := parsePubDate(doc, nsMap, &log.Logger)
+	if err != nil || date.String() != "2020-03" || date.Precision != eBookData.DATE_PRECISION_MONTH {
+		t.Errorf("Expected pub date '2020-03', got '%s' (err: %v)", date, err)
+	}
+	modified, _ := parseModified(doc, nsMap, &log.Logger)
+	if modified.String() != "2021-01-01" {
+		t.Errorf("Expected modification date '2021-01-01', got '%s'", modified)
+	}
+}
+
+func TestFindCoverStrategies(t *testing.T) {
+	jpgData := []byte{0xFF, 0xD8, 0xFF, 0xDB, 0x00, 0x43, 0x00, 0xFF, 0xD9}
+	coverPage := []byte(`<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:xlink="http://www.w3.org/1999/xlink"><body>
+<svg xmlns="http://www.w3.org/2000/svg"><image xlink:href="../images/front.jpg"/></svg>
+</body></html>`)
+	chapter := []byte(`<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Text</p><img src="../images/front.jpg"/></body></html>`)
+	nav := []byte(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="landmarks"><ol><li><a epub:type="cover" href="text/front.xhtml">Cover</a></li></ol></nav>
+</body></html>`)
+	files := map[string][]byte{
+		"OEBPS/images/front.jpg":   jpgData,
+		"OEBPS/text/front.xhtml":   coverPage,
+		"OEBPS/text/chapter.xhtml": chapter,
+		"OEBPS/nav.xhtml":          nav,
+	}
+	tests := []struct {
+		name     string
+		metadata string
+		manifest string
+		extra    string
+		source   eBookData.CoverSource
+	}{
+		{"properties", "", `<item id="img" href="images/front.jpg" media-type="image/jpeg" properties="cover-image"/>`, "", eBookData.COVER_SOURCE_PROPERTIES},
+		{"meta", `<meta name="cover" content="img"/>`, `<item id="img" href="images/front.jpg" media-type="image/jpeg"/>`, "", eBookData.COVER_SOURCE_META},
+		{"guide", "", `<item id="img" href="images/front.jpg" media-type="image/jpeg"/><item id="page" href="text/front.xhtml" media-type="application/xhtml+xml"/>`,
+			`<guide><reference type="cover" href="text/front.xhtml"/></guide>`, eBookData.COVER_SOURCE_GUIDE},
+		{"landmarks", "", `<item id="img" href="images/front.jpg" media-type="image/jpeg"/><item id="toc" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>`,
+			"", eBookData.COVER_SOURCE_LANDMARKS},
+		{"spine", "", `<item id="img" href="images/front.jpg" media-type="image/jpeg"/><item id="ch" href="text/chapter.xhtml" media-type="application/xhtml+xml"/>`,
+			`<spine><itemref idref="ch"/></spine>`, eBookData.COVER_SOURCE_SPINE},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opf := `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">` + test.metadata + `</metadata>
+	<manifest>` + test.manifest + `</manifest>` + test.extra + `
+</package>`
+			epub, err := ReadEpub(bytes.NewReader(createEpub(t, opf, files)), nil)
+			if err != nil {
+				t.Fatalf("ReadEpub failed: %v", err)
+			}
+			cover := epub.Cover()
+			if cover == nil {
+				t.Fatalf("No cover found")
+			}
+			if cover.Source != test.source || cover.Path != "OEBPS/images/front.jpg" || cover.MediaType != "image/jpeg" {
+				t.Errorf("Unexpected cover %s %s %s", cover.Source, cover.Path, cover.MediaType)
+			}
+		})
+	}
+}
+
+func TestFindCoverSpine(t *testing.T) {
+	opf := `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Spine</dc:title></metadata>
+	<manifest>
+		<item id="p1" href="p1.xhtml" media-type="application/xhtml+xml"/>
+		<item id="p2" href="p2.xhtml" media-type="application/xhtml+xml"/>
+		<item id="p3" href="p3.xhtml" media-type="application/xhtml+xml"/>
+		<item id="img" href="front.jpg" media-type="image/jpeg"/>
+	</manifest>
+	<spine><itemref idref="p1"/><itemref idref="p2"/><itemref idref="p3"/></spine>
+</package>`
+	page := []byte(`<html xmlns="http://www.w3.org/1999/xhtml"><body><img src="front.jpg"/></body></html>`)
+	text := []byte(`<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Text</p></body></html>`)
+	create := func(broken bool, pages ...[]byte) []byte {
+		buf := new(bytes.Buffer)
+		zw := zip.NewWriter(buf)
+		writeMimetype(zw)
+		files := map[string][]byte{
+			"META-INF/container.xml": containerXml("OEBPS/content.opf"),
+			"OEBPS/content.opf":      []byte(opf),
+			"OEBPS/front.jpg":        createTestImage(t, 10, 10),
+		}
+		for i, data := range pages {
+			if data != nil {
+				files["OEBPS/p"+strconv.Itoa(i+1)+".xhtml"] = data
+			}
+		}
+		for name, data := range files {
+			w, _ := zw.Create(name)
+			w.Write(data)
+		}
+		if broken {
+			// unknown compression method, it can't be opened
+			w, _ := zw.CreateRaw(&zip.FileHeader{Name: "OEBPS/p1.xhtml", Method: 99})
+			w.Write(text)
+		}
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	broken := create(true, nil, page, text)
+	epub, err := ReadEpub(bytes.NewReader(broken), nil)
+	if err != nil {
+		t.Fatalf("A broken spine page should not fail the lenient read: %v", err)
+	}
+	if cover := epub.Cover(); cover == nil || cover.Source != eBookData.COVER_SOURCE_SPINE {
+		t.Errorf("The cover of the second page should be found, got %+v", cover)
+	}
+	if _, err := ReadEpub(bytes.NewReader(broken), &eBookData.ParseOptions{Strict: true}); err == nil {
+		t.Errorf("A broken spine page should fail the strict read")
+	}
+
+	epub, err = ReadEpub(bytes.NewReader(create(false, text, text, page)), nil)
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	if epub.Cover() != nil {
+		t.Errorf("Only the first %d spine pages should be searched, got %+v", COVER_SPINE_ITEMS, epub.Cover())
+	}
+}
+
+func TestResolveHref(t *testing.T) {
+	tests := []struct {
+		base, href, expected string
+	}{
+		{"OEBPS/content.opf", "images/cover.jpg", "OEBPS/images/cover.jpg"},
+		{"OEBPS/content.opf", "images/my%20cover.jpg", "OEBPS/images/my cover.jpg"},
+		{"OEBPS/text/ch1.xhtml", "../images/a.png#frag", "OEBPS/images/a.png"},
+		{"OEBPS/text/ch1.xhtml", "#note", ""},
+		{"content.opf", "text/ch1.xhtml?x=1", "text/ch1.xhtml"},
+		{"OEBPS/content.opf", "/root.jpg", "root.jpg"},
+		{"OEBPS/content.opf", "../../etc/passwd", ""},
+		{"OEBPS/content.opf", "http://example.com/a.jpg", ""},
+	}
+	for _, test := range tests {
+		if got := resolveHref(test.base, test.href); got != test.expected {
+			t.Errorf("resolveHref(%q, %q) = %q, expected %q", test.base, test.href, got, test.expected)
+		}
+	}
+}
+
+func TestReadEpubCoverRelativeToOpf(t *testing.T) {
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Two covers</dc:title></metadata>
+	<manifest>
+		<item id="img" href="images/front%20cover.jpg" media-type="image/jpeg" properties="cover-image"/>
+	</manifest>
+</package>`, map[string][]byte{
+		"images/front cover.jpg":       []byte("wrong"),
+		"OEBPS/images/front cover.jpg": []byte("right"),
+	})
+	epub, err := ReadEpub(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	if epub.Cover() == nil || string(epub.Cover().Data) != "right" || epub.Cover().Path != "OEBPS/images/front cover.jpg" {
+		t.Errorf("Unexpected cover %+v", epub.Cover())
+	}
+}
+
+func TestReadEpub3Refines(t *testing.T) {
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+		<dc:title id="t2">A Subtitle</dc:title>
+		<meta refines="#t2" property="title-type">subtitle</meta>
+		<dc:title id="t1">The Main Title</dc:title>
+		<meta refines="#t1" property="title-type">main</meta>
+		<dc:creator id="c1">Jane Smith</dc:creator>
+		<meta refines="#c1" property="role" scheme="marc:relators">aut</meta>
+		<meta refines="#c1" property="file-as">Smith, Jane</meta>
+		<dc:creator id="c2">John Doe</dc:creator>
+		<meta refines="#c2" property="role" scheme="marc:relators">trl</meta>
+		<meta property="belongs-to-collection" id="s1">The Saga</meta>
+		<meta refines="#s1" property="collection-type">series</meta>
+		<meta refines="#s1" property="group-position">3</meta>
+		<meta name="calibre:series" content="Calibre Saga"/>
+		<meta name="calibre:series_index" content="1"/>
+	</metadata>
+</package>`, nil)
+
+	epub, err := ReadEpub(bytes.NewReader(data), &eBookData.ParseOptions{AuthorName: eBookData.AUTHOR_NAME_FILE_AS})
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	meta := epub.Metadata()
+	if meta.Title() != "The Main Title" || meta.Subtitle() != "A Subtitle" {
+		t.Errorf("Unexpected title '%s' / '%s'", meta.Title(), meta.Subtitle())
+	}
+	if authors := meta.Author(); len(authors) != 1 || authors[0] != "Smith, Jane" {
+		t.Errorf("Unexpected authors %v", authors)
+	}
+	if contributors := meta.Contributors(); len(contributors) != 1 || !contributors[0].HasRole(eBookData.ROLE_TRANSLATOR) {
+		t.Errorf("Unexpected contributors %+v", contributors)
+	}
+	if meta.Series() != "The Saga" || meta.SeriesIndex() != 3 {
+		t.Errorf("Unexpected series '%s' #%v", meta.Series(), meta.SeriesIndex())
+	}
+	if collections := meta.Collections(); len(collections) != 1 || collections[0].Type != "series" {
+		t.Errorf("Unexpected collections %+v", collections)
+	}
+}
+
+func TestReadEpubUntypedCollection(t *testing.T) {
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+		<dc:title>Collected</dc:title>
+		<dc:identifier id="uid">urn:uuid:1234</dc:identifier>
+		<meta property="belongs-to-collection" id="c1">Reading List</meta>
+		<meta name="calibre:series" content="Calibre Saga"/>
+		<meta name="calibre:series_index" content="2"/>
+	</metadata>
+</package>`, nil)
+
+	epub, err := ReadEpub(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	meta := epub.Metadata()
+	if meta.Series() != "Calibre Saga" || meta.SeriesIndex() != 2 {
+		t.Errorf("The collection without type should not replace the calibre series, got '%s' #%v", meta.Series(), meta.SeriesIndex())
+	}
+
+	editor, err := epub.Edit()
+	if err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+	editor.SetSeries("New Saga", 1)
+	buf := new(bytes.Buffer)
+	if err := editor.Write(buf); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	edited, err := ReadEpub(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatalf("ReadEpub of the edited epub failed: %v", err)
+	}
+	meta = edited.Metadata()
+	if meta.Series() != "New Saga" || meta.SeriesIndex() != 1 {
+		t.Errorf("Unexpected series '%s' #%v", meta.Series(), meta.SeriesIndex())
+	}
+	collections := meta.Collections()
+	if !slices.ContainsFunc(collections, func(c eBookData.Collection) bool { return c.Name == "Reading List" && c.Type == "" }) {
+		t.Errorf("SetSeries should keep the collection without type %+v", collections)
+	}
+}
+
+func TestParseIdentifierSchemes(t *testing.T) {
+	xml := `<package xmlns="http://www.idpf.org/2007/opf">
+		<metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+			<dc:identifier id="uuid_id" opf:scheme="uuid">urn:uuid:1234-abcd</dc:identifier>
+			<dc:identifier opf:scheme="AMAZON">B00TEST123</dc:identifier>
+			<dc:identifier>urn:isbn:9780306406157</dc:identifier>
+			<dc:identifier>google:abcDEF</dc:identifier>
+			<dc:identifier id="pub-doi">10.1000/182</dc:identifier>
+			<meta refines="#pub-doi" property="identifier-type" scheme="onix:codelist5">06</meta>
+		</metadata>
+	</package>`
+	doc := parseXML(t, xml)
+	nsMap := map[string]string{"dc": "http://purl.org/dc/elements/1.1/", "opf": "http://www.idpf.org/2007/opf"}
+
+	refines, _ := parseRefines(doc, nsMap, &log.Logger)
+	identifiers, err := parseIdentifiers(doc, nsMap, refines, &log.Logger)
+	if err != nil {
+		t.Fatalf("parseIdentifiers failed: %v", err)
+	}
+	expected := map[string]string{
+		"UUID":   "1234-abcd",
+		"ASIN":   "B00TEST123",
+		"ISBN":   "9780306406157",
+		"GOOGLE": "abcDEF",
+		"DOI":    "10.1000/182",
+	}
+	for scheme, value := range expected {
+		if found := eBookData.FindIdentifier(identifiers, scheme); found != value {
+			t.Errorf("Expected %s '%s', got '%s'", scheme, value, found)
+		}
+	}
+}
+
+func TestReadEpubIdentifiers(t *testing.T) {
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0" unique-identifier="local">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+		<dc:title>Identifiers</dc:title>
+		<dc:identifier id="local">shelf-42</dc:identifier>
+		<dc:identifier opf:scheme="ISBN">978-0-306-40615-7</dc:identifier>
+		<dc:identifier opf:scheme="ISBN">0-19-852663-6</dc:identifier>
+		<dc:identifier>urn:isbn:9780306406157</dc:identifier>
+	</metadata>
+</package>`, nil)
+
+	epub, err := ReadEpub(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	expected := []eBookData.Identifier{
+		{Scheme: "", Value: "shelf-42"},
+		{Scheme: eBookData.SCHEME_ISBN, Value: "9780306406157"},
+		{Scheme: eBookData.SCHEME_ISBN, Value: "9780198526636"},
+	}
+	if identifiers := epub.Metadata().Identifiers(); !slices.Equal(identifiers, expected) {
+		t.Errorf("Expected %v, got %v", expected, identifiers)
+	}
+	if epub.Metadata().ISBN() != "9780306406157" {
+		t.Errorf("The first ISBN should be the ISBN of the book, got '%s'", epub.Metadata().ISBN())
+	}
+
+	buf := new(bytes.Buffer)
+	content := EpubContent{
+		Metadata: epub.Metadata(),
+		Chapters: []Chapter{{Href: "text.xhtml", Content: []byte(`<html xmlns="http://www.w3.org/1999/xhtml"><head><title>Text</title></head><body><p>Text</p></body></html>`)}},
+	}
+	if err := WriteEpub(buf, content); err != nil {
+		t.Fatalf("WriteEpub failed: %v", err)
+	}
+	written, err := ReadEpub(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatalf("ReadEpub of the written epub failed: %v", err)
+	}
+	if identifiers := written.Metadata().Identifiers(); !slices.Equal(identifiers, expected) {
+		t.Errorf("The written identifiers should be the same, expected %v, got %v", expected, identifiers)
+	}
+}
+
+func TestReadEpubNormalizedIsbn(t *testing.T) {
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+		<dc:title>Isbn</dc:title>
+		<dc:identifier opf:scheme="ISBN">ISBN 0-306-40615-2</dc:identifier>
+	</metadata>
+</package>`, nil)
+
+	epub, err := ReadEpub(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	if epub.Metadata().ISBN() != "9780306406157" || eBookData.FindIdentifier(epub.Metadata().Identifiers(), "ISBN") != "9780306406157" {
+		t.Errorf("Expected normalized ISBN, got '%s'", epub.Metadata().ISBN())
+	}
+	epub, err = ReadEpub(bytes.NewReader(data), &eBookData.ParseOptions{KeepRawISBN: true})
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	if epub.Metadata().ISBN() != "ISBN 0-306-40615-2" {
+		t.Errorf("Expected raw ISBN, got '%s'", epub.Metadata().ISBN())
+	}
+}
+
+func TestReadEpubCalibreMetadata(t *testing.T) {
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+		<dc:title>The Hobbit</dc:title>
+		<meta name="calibre:title_sort" content="Hobbit, The"/>
+		<meta name="calibre:series" content="Middle-earth"/>
+		<meta name="calibre:series_index" content="1.0"/>
+		<meta name="calibre:rating" content="8.0"/>
+		<meta name="calibre:timestamp" content="2020-05-06T07:08:09.123456+00:00"/>
+		<meta name="calibre:user_categories" content='{"Favourites": [["J. R. R. Tolkien", "authors", 0]]}'/>
+		<meta name="calibre:user_metadata:#genre" content='{"label": "genre", "datatype": "text", "#value#": ["Fantasy", "Classic"]}'/>
+		<meta name="calibre:user_metadata:#read" content='{"label": "read", "datatype": "bool", "#value#": true}'/>
+		<meta name="calibre:user_metadata:#empty" content='{"label": "empty", "datatype": "text", "#value#": null}'/>
+	</metadata>
+</package>`, nil)
+
+	epub, err := ReadEpub(bytes.NewReader(data), nil)
 	if err != nil {
 		t.Fatalf("ReadEpub failed: %v", err)
 	}
-	if !bytes.HasPrefix(epub.cover, []byte{0xFF, 0xD8}) {
-		t.Errorf("Cover does not start with JPEG header: %x", epub.cover[:4])
+	meta := epub.Metadata()
+	if meta.SortTitle() != "Hobbit, The" {
+		t.Errorf("Unexpected sort title '%s'", meta.SortTitle())
+	}
+	if meta.Series() != "Middle-earth" || meta.SeriesIndex() != 1 {
+		t.Errorf("Unexpected series '%s' #%v", meta.Series(), meta.SeriesIndex())
+	}
+	if meta.Rating() != 4 {
+		t.Errorf("Expected 4 stars, got %v", meta.Rating())
+	}
+	if meta.Added().String() != "2020-05-06T07:08:09Z" {
+		t.Errorf("Unexpected added date '%s'", meta.Added())
+	}
+	if items := meta.UserCategories()["Favourites"]; len(items) != 1 || items[0] != "J. R. R. Tolkien" {
+		t.Errorf("Unexpected user categories %v", meta.UserCategories())
+	}
+	custom := meta.Custom()
+	if genres, ok := custom["genre"].([]any); !ok || len(genres) != 2 || genres[0] != "Fantasy" {
+		t.Errorf("Unexpected genre %v", custom["genre"])
+	}
+	if custom["read"] != true {
+		t.Errorf("Unexpected read %v", custom["read"])
+	}
+	if _, ok := custom["empty"]; ok {
+		t.Errorf("Empty column should be skipped")
+	}
+}
+
+func TestParseCalibreMeta(t *testing.T) {
+	xml := `<package xmlns="http://www.idpf.org/2007/opf">
+		<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+			<meta property="calibre:title_sort">Hobbit, The</meta>
+			<meta refines="#t1" property="calibre:rating">2</meta>
+		</metadata>
+	</package>`
+	doc := parseXML(t, xml)
+	nsMap := map[string]string{"dc": "http://purl.org/dc/elements/1.1/", "opf": "http://www.idpf.org/2007/opf"}
+
+	out := new(bytes.Buffer)
+	logger := zerolog.New(out).Level(zerolog.TraceLevel)
+	if value := parseCalibreMeta(doc, nsMap, "calibre:title_sort", &logger); value != "Hobbit, The" {
+		t.Errorf("Expected the EPUB3 meta, got '%s'", value)
+	}
+	if value := parseCalibreMeta(doc, nsMap, "calibre:rating", &logger); value != "" {
+		t.Errorf("The refining meta should be skipped, got '%s'", value)
+	}
+	if count := strings.Count(out.String(), "No calibre:rating found"); count != 1 {
+		t.Errorf("The missing meta should be logged once, got %d\n%s", count, out)
+	}
+}
+
+func TestReadEpubRenditions(t *testing.T) {
+	container := []byte(`<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:r="http://www.idpf.org/2013/rendition">
+	<rootfiles>
+		<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml" r:layout="pre-paginated" r:label="Fixed"/>
+		<rootfile full-path="book.pdf" media-type="application/pdf"/>
+		<rootfile full-path="reflow/content.opf" media-type="application/oebps-package+xml" r:layout="reflowable" r:language="fr"/>
+	</rootfiles>
+</container>`)
+	reflow := []byte(`<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Reflowable</dc:title></metadata>
+</package>`)
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Fixed layout</dc:title></metadata>
+</package>`, map[string][]byte{"META-INF/container.xml": container, "reflow/content.opf": reflow})
+
+	epub, err := ReadEpub(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	renditions := epub.Renditions()
+	if len(renditions) != 2 || renditions[0].Label != "Fixed" || renditions[1].Language != "fr" {
+		t.Fatalf("Unexpected renditions %+v", renditions)
+	}
+	if epub.Metadata().Title() != "Fixed layout" {
+		t.Errorf("Expected the default rendition, got '%s'", epub.Metadata().Title())
+	}
+
+	opts := &eBookData.ParseOptions{SelectRendition: func(renditions []eBookData.Rendition) int {
+		return slices.IndexFunc(renditions, func(r eBookData.Rendition) bool { return r.Layout == "reflowable" })
+	}}
+	epub, err = ReadEpub(bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	if epub.Metadata().Title() != "Reflowable" || epub.Rendition().FullPath != "reflow/content.opf" {
+		t.Errorf("Expected the reflowable rendition, got '%s'", epub.Metadata().Title())
+	}
+}
+
+// Helper: io.ReaderAt that counts the bytes read
+type countingReaderAt struct {
+	r    io.ReaderAt
+	read int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.read += int64(n)
+	return n, err
+}
+
+func TestReadEpubAtReadsOnlyNeededEntries(t *testing.T) {
+	// random data, so the deflate can't shrink it
+	illustration := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(illustration)
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Large</dc:title></metadata>
+	<manifest>
+		<item id="img" href="images/plate.png" media-type="image/png"/>
+	</manifest>
+</package>`, map[string][]byte{"OEBPS/images/plate.png": illustration})
+
+	reader := &countingReaderAt{r: bytes.NewReader(data)}
+	epub, err := ReadEpubAt(reader, int64(len(data)), nil)
+	if err != nil {
+		t.Fatalf("ReadEpubAt failed: %v", err)
+	}
+	if epub.Metadata().Title() != "Large" {
+		t.Errorf("Unexpected title '%s'", epub.Metadata().Title())
+	}
+	if reader.read > int64(len(data))/10 {
+		t.Errorf("Read %d bytes of %d, expected only the needed entries", reader.read, len(data))
+	}
+}
+
+// Helper: io.ReaderAt and io.Seeker without Size or Stat
+type seekingReaderAt struct {
+	r *bytes.Reader
+}
+
+func (s *seekingReaderAt) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+func (s *seekingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return s.r.ReadAt(p, off)
+}
+
+func (s *seekingReaderAt) Seek(offset int64, whence int) (int64, error) {
+	return s.r.Seek(offset, whence)
+}
+
+func TestReadEpubKeepsOffset(t *testing.T) {
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Offset</dc:title></metadata>
+</package>`, nil)
+
+	readers := map[string]io.ReadSeeker{
+		"bytes.Reader": bytes.NewReader(data),
+		"io.Seeker":    &seekingReaderAt{r: bytes.NewReader(data)},
+	}
+	for name, reader := range readers {
+		if _, err := reader.Seek(10, io.SeekStart); err != nil {
+			t.Fatalf("%s: Seek failed: %v", name, err)
+		}
+		epub, err := ReadEpub(reader, nil)
+		if err != nil {
+			t.Fatalf("%s: ReadEpub failed: %v", name, err)
+		}
+		if epub.Metadata().Title() != "Offset" {
+			t.Errorf("%s: Unexpected title '%s'", name, epub.Metadata().Title())
+		}
+		if offset, _ := reader.Seek(0, io.SeekCurrent); offset != 10 {
+			t.Errorf("%s: The offset of the reader should not change, got %d", name, offset)
+		}
+	}
+}
+
+func TestReadEpubTableOfContents(t *testing.T) {
+	ncx := []byte(`<?xml version="1.0"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+	<navMap>
+		<navPoint id="p1"><navLabel><text>Part One</text></navLabel><content src="text/part1.xhtml"/>
+			<navPoint id="c1"><navLabel><text>Chapter 1</text></navLabel><content src="text/ch1.xhtml#start"/></navPoint>
+		</navPoint>
+		<navPoint id="p2"><navLabel><text>Part Two</text></navLabel><content src="text/part2.xhtml"/></navPoint>
+	</navMap>
+</ncx>`)
+	nav := []byte(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="toc"><h1>Contents</h1><ol>
+	<li><span>Part One</span><ol><li><a href="ch1.xhtml#start">Chapter
+		1</a></li></ol></li>
+	<li><a href="part2.xhtml">Part Two</a></li>
+</ol></nav>
+</body></html>`)
+	epub2 := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Ncx</dc:title></metadata>
+	<manifest><item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/></manifest>
+	<spine toc="ncx"/>
+</package>`, map[string][]byte{"OEBPS/toc.ncx": ncx})
+	epub3 := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Nav</dc:title></metadata>
+	<manifest><item id="nav" href="text/nav.xhtml" media-type="application/xhtml+xml" properties="nav"/></manifest>
+</package>`, map[string][]byte{"OEBPS/text/nav.xhtml": nav})
+
+	for name, data := range map[string][]byte{"ncx": epub2, "nav": epub3} {
+		epub, err := ReadEpub(bytes.NewReader(data), nil)
+		if err != nil {
+			t.Fatalf("%s: ReadEpub failed: %v", name, err)
+		}
+		if epub.toc.load == nil {
+			t.Errorf("%s: The table of contents should not be parsed on read", name)
+		}
+		var book eBookData.Book = *epub
+		navigable, ok := book.(eBookData.Navigable)
+		if !ok {
+			t.Fatalf("%s: Epub is not Navigable", name)
+		}
+		toc := navigable.TableOfContents()
+		if epub.toc.load != nil || len(epub.TableOfContents()) != len(toc) {
+			t.Errorf("%s: The table of contents should be cached", name)
+		}
+		if len(toc) != 2 || toc[0].Label != "Part One" || toc[1].Label != "Part Two" || toc[1].Href != "OEBPS/text/part2.xhtml" {
+			t.Fatalf("%s: Unexpected toc %+v", name, toc)
+		}
+		if len(toc[0].Children) != 1 {
+			t.Fatalf("%s: Unexpected children %+v", name, toc[0].Children)
+		}
+		chapter := toc[0].Children[0]
+		if chapter.Label != "Chapter 1" || chapter.Href != "OEBPS/text/ch1.xhtml" || chapter.Fragment != "start" {
+			t.Errorf("%s: Unexpected chapter %+v", name, chapter)
+		}
+	}
+}
+
+func TestReadEpubSpine(t *testing.T) {
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Spine</dc:title></metadata>
+	<manifest>
+		<item id="ch1" href="text/ch1.xhtml" media-type="application/xhtml+xml" properties="scripted"/>
+		<item id="notes" href="text/notes.xhtml" media-type="application/xhtml+xml"/>
+		<item id="ch2" href="text/ch%202.xhtml" media-type="application/xhtml+xml"/>
+	</manifest>
+	<spine>
+		<itemref idref="ch1" properties="page-spread-right"/>
+		<itemref idref="missing"/>
+		<itemref idref="notes" linear="no"/>
+		<itemref idref="ch2"/>
+	</spine>
+</package>`, map[string][]byte{
+		"OEBPS/text/ch1.xhtml":   []byte("<html><body><p>One</p></body></html>"),
+		"OEBPS/text/notes.xhtml": []byte("<html><body><p>Notes</p></body></html>"),
+		"OEBPS/text/ch 2.xhtml":  []byte("<html><body><p>Two</p></body></html>"),
+	})
+	epub, err := ReadEpub(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	spine := epub.Spine()
+	if len(spine) != 3 {
+		t.Fatalf("Unexpected spine %+v", spine)
+	}
+	if spine[0].ID != "ch1" || spine[0].Href != "OEBPS/text/ch1.xhtml" || !spine[0].Linear {
+		t.Errorf("Unexpected first item %+v", spine[0])
+	}
+	if !slices.Equal(spine[0].Properties, []string{"scripted", "page-spread-right"}) {
+		t.Errorf("Unexpected properties %v", spine[0].Properties)
+	}
+	if spine[1].ID != "notes" || spine[1].Linear {
+		t.Errorf("Unexpected second item %+v", spine[1])
+	}
+	if spine[2].Href != "OEBPS/text/ch 2.xhtml" || spine[2].MediaType != "application/xhtml+xml" {
+		t.Errorf("Unexpected third item %+v", spine[2])
+	}
+	reader, err := epub.OpenItem(spine[2])
+	if err != nil {
+		t.Fatalf("OpenItem failed: %v", err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil || !strings.Contains(string(content), "Two") {
+		t.Errorf("Unexpected content %q, %v", content, err)
+	}
+	if _, err := epub.OpenItem(SpineItem{Href: "OEBPS/text/none.xhtml"}); err == nil {
+		t.Errorf("OpenItem of a missing file should fail")
+	}
+}
+
+func TestOpenItemEncodedName(t *testing.T) {
+	// the zip name is percent-encoded like the href
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Encoded</dc:title></metadata>
+	<manifest><item id="ch1" href="text/chapter%201.xhtml" media-type="application/xhtml+xml"/></manifest>
+	<spine><itemref idref="ch1"/></spine>
+</package>`, map[string][]byte{"OEBPS/text/chapter%201.xhtml": []byte("<html><body><p>One</p></body></html>")})
+	epub, err := ReadEpub(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	spine := epub.Spine()
+	if len(spine) != 1 {
+		t.Fatalf("Unexpected spine %+v", spine)
+	}
+	reader, err := epub.OpenItem(spine[0])
+	if err != nil {
+		t.Fatalf("OpenItem failed: %v", err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil || !strings.Contains(string(content), "One") {
+		t.Errorf("Unexpected content %q, %v", content, err)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	nav := []byte(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="toc"><ol><li><a href="ch1.xhtml">The Beginning</a></li></ol></nav>
+</body></html>`)
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Text</dc:title></metadata>
+	<manifest>
+		<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+		<item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+		<item id="notes" href="notes.xhtml" media-type="application/xhtml+xml"/>
+		<item id="img" href="image.svg" media-type="image/svg+xml"/>
+	</manifest>
+	<spine>
+		<itemref idref="ch1"/>
+		<itemref idref="img"/>
+		<itemref idref="notes" linear="no"/>
+	</spine>
+</package>`, map[string][]byte{
+		"OEBPS/nav.xhtml": nav,
+		"OEBPS/ch1.xhtml": []byte(`<html><head><title>Ignored</title><style>p { color: red }</style></head><body>
+<h1>Chapter  One</h1>
+<p>First
+	paragraph with <em>emphasis</em>.</p><script>var x = 1;</script>
+<p>Second<br/>line &amp; more</p>
+</body></html>`),
+		"OEBPS/notes.xhtml": []byte(`<html><body><p>A note</p></body></html>`),
+	})
+	epub, err := ReadEpub(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	var out strings.Builder
+	if err := epub.WriteText(&out, nil); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	expected := "=== 1: The Beginning ===\n\nChapter One\n\nFirst paragraph with emphasis.\n\nSecond\nline & more\n"
+	if out.String() != expected {
+		t.Errorf("Unexpected text %q", out.String())
+	}
+
+	out.Reset()
+	opts := &TextOptions{
+		IncludeNonLinear: true,
+		ChapterMarker: func(index int, item SpineItem, title string) string {
+			return "# " + item.ID
+		},
+	}
+	if err := epub.WriteText(&out, opts); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "# ch1\n\n") || !strings.HasSuffix(out.String(), "\n\n# notes\n\nA note\n") {
+		t.Errorf("Unexpected text with options %q", out.String())
+	}
+}
+
+func TestEpubFS(t *testing.T) {
+	css := []byte("body { margin: 0 }")
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>FS</dc:title></metadata>
+	<manifest>
+		<item id="css" href="styles/book.css" media-type="text/css"/>
+		<item id="font" href="fonts/serif.otf" media-type="font/otf"/>
+	</manifest>
+</package>`, map[string][]byte{
+		"OEBPS/styles/book.css": css,
+		"OEBPS/fonts/serif.otf": []byte("font"),
+	})
+	epub, err := ReadEpub(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	fsys := epub.FS()
+	if err := fstest.TestFS(fsys, "mimetype", "OEBPS/content.opf", "OEBPS/styles/book.css", "OEBPS/fonts/serif.otf"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := fs.Stat(fsys, "OEBPS/styles/book.css")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if mediaType := info.(FileInfo).MediaType(); mediaType != "text/css" {
+		t.Errorf("Unexpected media type %q", mediaType)
+	}
+	if info.Size() != int64(len(css)) {
+		t.Errorf("Unexpected size %d", info.Size())
+	}
+	mediaTypes := make(map[string]string)
+	fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		mediaTypes[name] = info.(FileInfo).MediaType()
+		return nil
+	})
+	if mediaTypes["OEBPS/fonts/serif.otf"] != "font/otf" || mediaTypes["mimetype"] != "" {
+		t.Errorf("Unexpected media types %v", mediaTypes)
+	}
+
+	for _, name := range []string{"../mimetype", "/mimetype", "OEBPS/../mimetype", "OEBPS/styles/../../../etc/passwd"} {
+		if _, err := fsys.Open(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Open(%q) should be invalid, got %v", name, err)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	http.FileServer(http.FS(fsys)).ServeHTTP(recorder, httptest.NewRequest("GET", "/OEBPS/styles/book.css", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != string(css) {
+		t.Errorf("Unexpected response %d %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestWriteEpubRoundTrip(t *testing.T) {
+	published, _ := eBookData.ParseDate("2021-05-04")
+	modified, _ := eBookData.ParseDate("2024-01-02T03:04:05Z")
+	metadata := epubMetadata{
+		title:          "Written <Book>",
+		subtitle:       "A Test",
+		authors:        []eBookData.Person{{Name: "Jane Doe", FileAs: "Doe, Jane", Roles: []string{eBookData.ROLE_AUTHOR}}},
+		contributors:   []eBookData.Person{{Name: "John Roe", Roles: []string{eBookData.ROLE_TRANSLATOR}}},
+		publisher:      "Publisher & Co",
+		publishingDate: published,
+		language:       "hu",
+		description:    "Description",
+		subjects:       []string{"Fiction", "Test"},
+		series:         "Series",
+		seriesIndex:    2.5,
+		rights:         "CC-BY",
+		identifiers:    []eBookData.Identifier{{Scheme: eBookData.SCHEME_ISBN, Value: "9780306406157"}, {Scheme: "MYCMS", Value: "book-42"}},
+		modified:       modified,
+		calibre:        calibreData{titleSort: "Written Book", rating: 4},
+	}
+	chapter := func(title string, text string) []byte {
+		return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>` + title + `</title><link rel="stylesheet" href="../style.css"/></head>
+<body><h1>` + title + `</h1><p>` + text + `</p></body></html>`)
+	}
+	cover := createTestImage(t, 4, 6)
+	buf := new(bytes.Buffer)
+	err := WriteEpub(buf, EpubContent{
+		Metadata: metadata,
+		Chapters: []Chapter{
+			{Href: "text/chapter 1.xhtml", Title: "Chapter 1", Content: chapter("Chapter 1", "First")},
+			{Href: "text/chapter2.xhtml", Title: "Chapter 2", Content: chapter("Chapter 2", "Second")},
+			{Href: "text/notes.xhtml", Content: chapter("Notes", "Note"), NonLinear: true},
+		},
+		Resources: []Resource{{Href: "style.css", Data: []byte("p { margin: 0 }")}},
+		Cover:     &Resource{Href: "images/cover.png", MediaType: "image/png", Data: cover},
+	})
+	if err != nil {
+		t.Fatalf("WriteEpub failed: %v", err)
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Invalid zip: %v", err)
+	}
+	first := zipReader.File[0]
+	if first.Name != "mimetype" || first.Method != zip.Store || len(first.Extra) != 0 {
+		t.Errorf("Unexpected first entry %s method %d extra %v", first.Name, first.Method, first.Extra)
+	}
+	if !bytes.Equal(buf.Bytes()[30:58], []byte("mimetypeapplication/epub+zip")) {
+		t.Errorf("mimetype is not at the start of the file: %q", buf.Bytes()[30:58])
+	}
+
+	epub, err := ReadEpub(bytes.NewReader(buf.Bytes()), &eBookData.ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	read := epub.Metadata()
+	if read.Title() != metadata.title || read.Subtitle() != metadata.subtitle || read.SortTitle() != "Written Book" {
+		t.Errorf("Unexpected title %q %q %q", read.Title(), read.Subtitle(), read.SortTitle())
+	}
+	if authors := read.Authors(); len(authors) != 1 || authors[0].FileAs != "Doe, Jane" || !authors[0].IsAuthor() {
+		t.Errorf("Unexpected authors %+v", authors)
+	}
+	if contributors := read.Contributors(); len(contributors) != 1 || !contributors[0].HasRole(eBookData.ROLE_TRANSLATOR) {
+		t.Errorf("Unexpected contributors %+v", contributors)
+	}
+	if read.Publisher() != metadata.publisher || read.Language() != "hu" || read.Description() != "Description" || read.Rights() != "CC-BY" {
+		t.Errorf("Unexpected metadata %q %q %q %q", read.Publisher(), read.Language(), read.Description(), read.Rights())
+	}
+	if read.PublishedDate().String() != "2021-05-04" || !read.Modified().Time.Equal(modified.Time) {
+		t.Errorf("Unexpected dates %v %v", read.PublishedDate(), read.Modified())
+	}
+	if !slices.Equal(read.Subjects(), metadata.subjects) || read.Series() != "Series" || read.SeriesIndex() != 2.5 || read.Rating() != 4 {
+		t.Errorf("Unexpected subjects %v series %q %v rating %v", read.Subjects(), read.Series(), read.SeriesIndex(), read.Rating())
+	}
+	if read.ISBN() != "9780306406157" || eBookData.FindIdentifier(read.Identifiers(), "MYCMS") != "book-42" {
+		t.Errorf("Unexpected identifiers %v", read.Identifiers())
+	}
+
+	if epub.Cover() == nil || epub.Cover().Source != eBookData.COVER_SOURCE_PROPERTIES || epub.Cover().Width != 4 {
+		t.Errorf("Unexpected cover %+v", epub.Cover())
+	}
+	spine := epub.Spine()
+	if len(spine) != 3 || spine[0].Href != "OEBPS/text/chapter 1.xhtml" || spine[2].Linear {
+		t.Errorf("Unexpected spine %+v", spine)
+	}
+	toc := epub.TableOfContents()
+	if len(toc) != 2 || toc[0].Label != "Chapter 1" || toc[0].Href != "OEBPS/text/chapter 1.xhtml" {
+		t.Errorf("Unexpected toc %+v", toc)
+	}
+	info, err := fs.Stat(epub.FS(), "OEBPS/style.css")
+	if err != nil || info.(FileInfo).MediaType() != "text/css" {
+		t.Errorf("Unexpected stylesheet %v %v", info, err)
+	}
+	ncx, err := fs.ReadFile(epub.FS(), "OEBPS/toc.ncx")
+	if err != nil || !strings.Contains(string(ncx), "urn:isbn:9780306406157") {
+		t.Errorf("Unexpected NCX %q %v", ncx, err)
+	}
+}
+
+func TestWriteEpubInvalidContent(t *testing.T) {
+	metadata := epubMetadata{title: "Invalid"}
+	chapter := Chapter{Href: "chapter.xhtml", Content: []byte("<html/>")}
+	contents := map[string]EpubContent{
+		"no metadata":   {Chapters: []Chapter{chapter}},
+		"no title":      {Metadata: epubMetadata{}, Chapters: []Chapter{chapter}},
+		"no chapter":    {Metadata: metadata},
+		"traversal":     {Metadata: metadata, Chapters: []Chapter{{Href: "../chapter.xhtml"}}},
+		"duplicate":     {Metadata: metadata, Chapters: []Chapter{chapter, chapter}},
+		"reserved":      {Metadata: metadata, Chapters: []Chapter{{Href: "nav.xhtml"}}},
+		"no media type": {Metadata: metadata, Chapters: []Chapter{chapter}, Resources: []Resource{{Href: "data.unknown-ext"}}},
+	}
+	for name, content := range contents {
+		if err := WriteEpub(io.Discard, content); err == nil {
+			t.Errorf("%s: WriteEpub should fail", name)
+		}
+	}
+}
+
+func TestWriteEpubMediaTypes(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := WriteEpub(buf, EpubContent{
+		Metadata: epubMetadata{title: "Fonts"},
+		Chapters: []Chapter{{Href: "chapter.xhtml", Content: []byte("<html/>")}},
+		Resources: []Resource{
+			{Href: "fonts/a.ttf"},
+			{Href: "fonts/b.otf"},
+			{Href: "fonts/c.woff"},
+			{Href: "fonts/d.WOFF2"},
+			{Href: "text/appendix.xhtml"},
+			{Href: "audio/overlay.smil"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("WriteEpub failed: %v", err)
+	}
+	epub, err := ReadEpub(bytes.NewReader(buf.Bytes()), &eBookData.ParseOptions{})
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	expected := map[string]string{
+		"OEBPS/chapter.xhtml":       "application/xhtml+xml",
+		"OEBPS/fonts/a.ttf":         "font/ttf",
+		"OEBPS/fonts/b.otf":         "font/otf",
+		"OEBPS/fonts/c.woff":        "font/woff",
+		"OEBPS/fonts/d.WOFF2":       "font/woff2",
+		"OEBPS/text/appendix.xhtml": "application/xhtml+xml",
+		"OEBPS/audio/overlay.smil":  "application/smil+xml",
+		"OEBPS/toc.ncx":             "application/x-dtbncx+xml",
+	}
+	for name, mediaType := range expected {
+		info, err := fs.Stat(epub.FS(), name)
+		if err != nil || info.(FileInfo).MediaType() != mediaType {
+			t.Errorf("%s: unexpected media type %v %v", name, info, err)
+		}
+	}
+}
+
+// failingWriter accepts limit bytes then fails
+type failingWriter struct {
+	limit int
+	err   error
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, w.err
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestWriteEpubWriteError(t *testing.T) {
+	diskFull := errors.New("disk full")
+	content := EpubContent{
+		Metadata: epubMetadata{title: "Failing"},
+		Chapters: []Chapter{{Href: "chapter.xhtml", Content: []byte("<html/>")}},
+	}
+	for _, limit := range []int{0, 10, 100, 1000} {
+		err := WriteEpub(&failingWriter{limit: limit, err: diskFull}, content)
+		if !errors.Is(err, diskFull) {
+			t.Errorf("%d: unexpected error %v", limit, err)
+			continue
+		}
+		if strings.Contains(err.Error(), "Epub format error") {
+			t.Errorf("%d: I/O error reported as format error: %v", limit, err)
+		}
+	}
+}
+
+func createTestImage(t *testing.T, width int, height int) []byte {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("Failed to encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func rawZipEntries(t *testing.T, data []byte) map[string][]byte {
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Invalid zip: %v", err)
+	}
+	ret := make(map[string][]byte)
+	for _, file := range zipReader.File {
+		reader, err := file.OpenRaw()
+		if err != nil {
+			t.Fatalf("OpenRaw failed: %v", err)
+		}
+		ret[file.Name], _ = io.ReadAll(reader)
+	}
+	return ret
+}
+
+func TestEditEpub(t *testing.T) {
+	cover := createTestImage(t, 4, 6)
+	chapter := []byte("<html><body><p>Chapter text that is long enough to be compressed, compressed, compressed.</p></body></html>")
+	opfs := map[string]string{
+		"epub2": `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0" unique-identifier="BookId">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:title>Old Title</dc:title>
+    <dc:creator opf:role="aut" opf:file-as="Author, Old">Old Author</dc:creator>
+    <dc:creator opf:role="ill">Illustrator</dc:creator>
+    <dc:identifier id="BookId" opf:scheme="UUID">urn:uuid:1234</dc:identifier>
+    <dc:identifier opf:scheme="ISBN">0-306-40615-2</dc:identifier>
+    <meta name="calibre:series" content="Old Series"/>
+    <meta name="calibre:series_index" content="1"/>
+    <meta name="cover" content="cover"/>
+  </metadata>
+  <manifest>
+    <item id="cover" href="cover.png" media-type="image/png"/>
+    <item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine><itemref idref="ch1"/></spine>
+</package>`,
+		"epub3": `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title id="t1">Old Title</dc:title>
+    <dc:title id="t2">Old Subtitle</dc:title>
+    <dc:creator id="c1">Old Author</dc:creator>
+    <dc:creator id="c2">Illustrator</dc:creator>
+    <dc:identifier id="uid">urn:uuid:1234</dc:identifier>
+    <dc:identifier id="isbn">urn:isbn:0306406152</dc:identifier>
+    <meta property="belongs-to-collection" id="s1">Old Series</meta>
+    <meta property="dcterms:modified">2020-01-01T00:00:00Z</meta>
+    <meta refines="#t1" property="title-type">main</meta>
+    <meta refines="#t2" property="title-type">subtitle</meta>
+    <meta refines="#c1" property="role" scheme="marc:relators">aut</meta>
+    <meta refines="#c2" property="role" scheme="marc:relators">ill</meta>
+    <meta refines="#s1" property="collection-type">series</meta>
+  </metadata>
+  <manifest>
+    <item id="cover" href="cover.png" media-type="image/png" properties="cover-image"/>
+    <item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine><itemref idref="ch1"/></spine>
+</package>`,
+	}
+	for name, opf := range opfs {
+		data := createEpub(t, opf, map[string][]byte{"OEBPS/cover.png": cover, "OEBPS/ch1.xhtml": chapter})
+		epub, err := ReadEpub(bytes.NewReader(data), nil)
+		if err != nil {
+			t.Fatalf("%s: ReadEpub failed: %v", name, err)
+		}
+		editor, err := epub.Edit()
+		if err != nil {
+			t.Fatalf("%s: Edit failed: %v", name, err)
+		}
+		if err := editor.SetTitle("New Title"); err != nil {
+			t.Fatalf("%s: SetTitle failed: %v", name, err)
+		}
+		editor.SetAuthors([]eBookData.Person{{Name: "New Author", FileAs: "Author, New"}, {Name: "Second Author"}})
+		editor.SetSeries("New Series", 3)
+		if err := editor.SetIdentifier(eBookData.SCHEME_ISBN, "9780306406157"); err != nil {
+			t.Fatalf("%s: SetIdentifier failed: %v", name, err)
+		}
+		if err := editor.SetIdentifier("mycms", "book-42"); err != nil {
+			t.Fatalf("%s: SetIdentifier failed: %v", name, err)
+		}
+		if err := editor.RemoveIdentifier(eBookData.SCHEME_UUID); err == nil {
+			t.Errorf("%s: The unique identifier should not be removable", name)
+		}
+		editor.SetPublisher("New Publisher")
+		newCover := createTestImage(t, 8, 12)
+		if err := editor.ReplaceCover(newCover, ""); err != nil {
+			t.Fatalf("%s: ReplaceCover failed: %v", name, err)
+		}
+		buf := new(bytes.Buffer)
+		if err := editor.Write(buf); err != nil {
+			t.Fatalf("%s: Write failed: %v", name, err)
+		}
+
+		edited, err := ReadEpub(bytes.NewReader(buf.Bytes()), &eBookData.ParseOptions{Strict: true})
+		if err != nil {
+			t.Fatalf("%s: ReadEpub of the edited epub failed: %v", name, err)
+		}
+		metadata := edited.Metadata()
+		if metadata.Title() != "New Title" {
+			t.Errorf("%s: Unexpected title %q", name, metadata.Title())
+		}
+		if name == "epub3" && metadata.Subtitle() != "Old Subtitle" {
+			t.Errorf("%s: Unexpected subtitle %q", name, metadata.Subtitle())
+		}
+		authors := metadata.Authors()
+		if len(authors) != 2 || authors[0].Name != "New Author" || authors[0].FileAs != "Author, New" || authors[1].Name != "Second Author" {
+			t.Errorf("%s: Unexpected authors %+v", name, authors)
+		}
+		if contributors := metadata.Contributors(); len(contributors) != 1 || contributors[0].Name != "Illustrator" {
+			t.Errorf("%s: Unexpected contributors %+v", name, contributors)
+		}
+		if metadata.Series() != "New Series" || metadata.SeriesIndex() != 3 {
+			t.Errorf("%s: Unexpected series %q %v", name, metadata.Series(), metadata.SeriesIndex())
+		}
+		identifiers := metadata.Identifiers()
+		if eBookData.FindIdentifier(identifiers, eBookData.SCHEME_ISBN) != "9780306406157" || eBookData.FindIdentifier(identifiers, eBookData.SCHEME_UUID) != "1234" || eBookData.FindIdentifier(identifiers, "MYCMS") != "book-42" {
+			t.Errorf("%s: Unexpected identifiers %v", name, identifiers)
+		}
+		if metadata.Publisher() != "New Publisher" {
+			t.Errorf("%s: Unexpected publisher %q", name, metadata.Publisher())
+		}
+		if edited.Cover() == nil || edited.Cover().Width != 8 {
+			t.Errorf("%s: Unexpected cover %+v", name, edited.Cover())
+		}
+		if name == "epub3" && metadata.Modified().Year() < 2025 {
+			t.Errorf("%s: Modification date not updated %v", name, metadata.Modified())
+		}
+		editedMetadata, err := editor.Metadata()
+		if err != nil || editedMetadata.Title() != "New Title" {
+			t.Errorf("%s: Unexpected editor metadata %v", name, err)
+		}
+
+		zipReader, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if first := zipReader.File[0]; first.Name != "mimetype" || first.Method != zip.Store {
+			t.Errorf("%s: Unexpected first entry %s", name, first.Name)
+		}
+		original := rawZipEntries(t, data)
+		for entry, raw := range rawZipEntries(t, buf.Bytes()) {
+			switch entry {
+			case "OEBPS/content.opf", "OEBPS/cover.png":
+			default:
+				if !bytes.Equal(raw, original[entry]) {
+					t.Errorf("%s: %s changed", name, entry)
+				}
+			}
+		}
+		if len(zipReader.File) != len(original) {
+			t.Errorf("%s: Unexpected entry count %d", name, len(zipReader.File))
+		}
+		opfFile, _ := fs.ReadFile(edited.FS(), "OEBPS/content.opf")
+		if strings.Contains(string(opfFile), "Old") && !strings.Contains(string(opfFile), "Old Subtitle") || strings.Contains(string(opfFile), "\n\n") || strings.Contains(string(opfFile), "<opf:meta") {
+			t.Errorf("%s: Unexpected package document\n%s", name, opfFile)
+		}
+	}
+}
+
+func TestEditEpubReplaceMissingCover(t *testing.T) {
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Missing Cover</dc:title><dc:identifier id="id">x</dc:identifier></metadata>
+  <manifest><item id="img" href="images/cover.png" media-type="image/png" properties="cover-image"/></manifest>
+</package>`, nil)
+	epub, err := ReadEpub(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	editor, err := epub.Edit()
+	if err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+	if err := editor.ReplaceCover(createTestImage(t, 6, 9), ""); err != nil {
+		t.Fatalf("ReplaceCover failed: %v", err)
+	}
+	buf := new(bytes.Buffer)
+	if err := editor.Write(buf); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	edited, err := ReadEpub(bytes.NewReader(buf.Bytes()), &eBookData.ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadEpub of the edited epub failed: %v", err)
+	}
+	if cover := edited.Cover(); cover == nil || cover.Path != "OEBPS/images/cover.png" || cover.Width != 6 || cover.Height != 9 {
+		t.Errorf("The missing cover file should be added, got %+v", cover)
+	}
+}
+
+func TestEditEpubReplaceCoverMediaType(t *testing.T) {
+	jpg := new(bytes.Buffer)
+	if err := jpeg.Encode(jpg, image.NewRGBA(image.Rect(0, 0, 10, 15)), nil); err != nil {
+		t.Fatalf("Failed to encode jpeg: %v", err)
+	}
+	data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Cover Type</dc:title><dc:identifier id="id">x</dc:identifier></metadata>
+  <manifest>
+    <item id="img" href="images/cover.png" media-type="image/png" properties="cover-image"/>
+    <item id="page" href="text/titlepage.xhtml" media-type="application/xhtml+xml"/>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+  </manifest>
+  <spine>
+    <itemref idref="page"/>
+  </spine>
+</package>`, map[string][]byte{
+		"OEBPS/images/cover.png":     createTestImage(t, 4, 6),
+		"OEBPS/text/titlepage.xhtml": []byte(`<html><body><img src="../images/cover.png" alt="Cover"/></body></html>`),
+		"OEBPS/nav.xhtml":            []byte(`<html><body><nav><a href="images/cover.png#x">Cover</a></nav></body></html>`),
+	})
+	out := new(bytes.Buffer)
+	logger := zerolog.New(out).Level(zerolog.TraceLevel)
+	epub, err := ReadEpub(bytes.NewReader(data), &eBookData.ParseOptions{Logger: &logger})
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	editor, err := epub.Edit()
+	if err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+	if err := editor.ReplaceCover(jpg.Bytes(), ""); err != nil {
+		t.Fatalf("ReplaceCover failed: %v", err)
+	}
+	buf := new(bytes.Buffer)
+	if err := editor.Write(buf); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	edited, err := ReadEpub(bytes.NewReader(buf.Bytes()), &eBookData.ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadEpub of the edited epub failed: %v", err)
+	}
+	if cover := edited.Cover(); cover == nil || cover.Path != "OEBPS/images/cover.jpg" || cover.MediaType != "image/jpeg" || cover.Width != 10 {
+		t.Errorf("Unexpected cover %+v", cover)
+	}
+	if _, err := fs.Stat(edited.FS(), "OEBPS/images/cover.png"); err == nil {
+		t.Errorf("The old cover file should be removed")
+	}
+	page, _ := fs.ReadFile(edited.FS(), "OEBPS/text/titlepage.xhtml")
+	nav, _ := fs.ReadFile(edited.FS(), "OEBPS/nav.xhtml")
+	if !strings.Contains(string(page), `src="../images/cover.jpg"`) || !strings.Contains(string(nav), `href="images/cover.jpg#x"`) {
+		t.Errorf("Unexpected links\n%s\n%s", page, nav)
+	}
+	opf, _ := fs.ReadFile(edited.FS(), "OEBPS/content.opf")
+	if !strings.Contains(string(opf), `href="images/cover.jpg" media-type="image/jpeg"`) {
+		t.Errorf("Unexpected package document %s", opf)
+	}
+	if !strings.Contains(out.String(), `"Replaced qrt":3,"Added qrt":1,"Removed qrt":1,"message":"Edited epub written"`) {
+		t.Errorf("Unexpected log %s", out.String())
+	}
+
+	editor, _ = edited.Edit()
+	if err := editor.ReplaceCover(createTestImage(t, 4, 6), "image/tiff"); err == nil {
+		t.Errorf("Unsupported cover media type should fail")
+	}
+}
+
+func TestEditEpubSetCover(t *testing.T) {
+	oldCover := createTestImage(t, 4, 6)
+	newCover := createTestImage(t, 8, 12)
+	chapter := []byte("<html><body><p>Text</p></body></html>")
+	oldPage := []byte(`<html><body><img src="images/old.png"/></body></html>`)
+
+	t.Run("epub2 without cover", func(t *testing.T) {
+		data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>No Cover</dc:title><dc:identifier id="id">x</dc:identifier></metadata>
+  <manifest>
+    <item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="ch1"/>
+  </spine>
+</package>`, map[string][]byte{"OEBPS/ch1.xhtml": chapter})
+		epub, _ := ReadEpub(bytes.NewReader(data), nil)
+		edited := editCover(t, epub, newCover, &CoverOptions{CoverPage: true})
+		if edited.Cover() == nil || edited.Cover().Source != eBookData.COVER_SOURCE_META || edited.Cover().Width != 8 || edited.Cover().Path != "OEBPS/cover.png" {
+			t.Errorf("Unexpected cover %+v", edited.Cover())
+		}
+		spine := edited.Spine()
+		if len(spine) != 2 || spine[0].Href != "OEBPS/cover.xhtml" || spine[1].ID != "ch1" {
+			t.Fatalf("Unexpected spine %+v", spine)
+		}
+		page, _ := fs.ReadFile(edited.FS(), "OEBPS/cover.xhtml")
+		if !strings.Contains(string(page), `<img src="cover.png"`) {
+			t.Errorf("Unexpected cover page %s", page)
+		}
+		opf, _ := fs.ReadFile(edited.FS(), "OEBPS/content.opf")
+		if !strings.Contains(string(opf), `<reference type="cover" title="Cover" href="cover.xhtml"/>`) || strings.Contains(string(opf), "properties") {
+			t.Errorf("Unexpected package document %s", opf)
+		}
+	})
+
+	t.Run("epub3 with old cover page", func(t *testing.T) {
+		data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Old Cover</dc:title><dc:identifier id="id">x</dc:identifier></metadata>
+  <manifest>
+    <item id="old" href="images/old.png" media-type="image/png" properties="cover-image"/>
+    <item id="old-page" href="titlepage.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="old-page"/>
+    <itemref idref="ch1"/>
+  </spine>
+  <guide>
+    <reference type="cover" href="titlepage.xhtml"/>
+  </guide>
+</package>`, map[string][]byte{"OEBPS/images/old.png": oldCover, "OEBPS/titlepage.xhtml": oldPage, "OEBPS/ch1.xhtml": chapter})
+		epub, _ := ReadEpub(bytes.NewReader(data), nil)
+		edited := editCover(t, epub, newCover, &CoverOptions{CoverPage: true})
+		if edited.Cover() == nil || edited.Cover().Source != eBookData.COVER_SOURCE_PROPERTIES || edited.Cover().Width != 8 {
+			t.Errorf("Unexpected cover %+v", edited.Cover())
+		}
+		spine := edited.Spine()
+		if len(spine) != 2 || spine[0].Href != "OEBPS/cover.xhtml" || spine[1].ID != "ch1" {
+			t.Fatalf("Unexpected spine %+v", spine)
+		}
+		for _, name := range []string{"OEBPS/images/old.png", "OEBPS/titlepage.xhtml"} {
+			if _, err := fs.Stat(edited.FS(), name); err == nil {
+				t.Errorf("%s should be removed", name)
+			}
+		}
+		opf, _ := fs.ReadFile(edited.FS(), "OEBPS/content.opf")
+		if strings.Contains(string(opf), "old") || strings.Contains(string(opf), "titlepage") || !strings.Contains(string(opf), `<meta name="cover" content="cover-image-1"/>`) {
+			t.Errorf("Unexpected package document %s", opf)
+		}
+	})
+
+	t.Run("epub3 with referenced old cover", func(t *testing.T) {
+		data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Old Cover</dc:title><dc:identifier id="id">x</dc:identifier></metadata>
+  <manifest>
+    <item id="old" href="images/old.png" media-type="image/png" properties="cover-image"/>
+    <item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="ch1"/>
+  </spine>
+</package>`, map[string][]byte{"OEBPS/images/old.png": oldCover, "OEBPS/ch1.xhtml": oldPage})
+		epub, _ := ReadEpub(bytes.NewReader(data), nil)
+		edited := editCover(t, epub, newCover, nil)
+		if edited.Cover() == nil || edited.Cover().Path != "OEBPS/cover.png" {
+			t.Errorf("Unexpected cover %+v", edited.Cover())
+		}
+		if _, err := fs.Stat(edited.FS(), "OEBPS/images/old.png"); err != nil {
+			t.Errorf("The referenced old cover should be kept: %v", err)
+		}
+		if len(edited.Spine()) != 1 {
+			t.Errorf("Unexpected spine %+v", edited.Spine())
+		}
+		opf, _ := fs.ReadFile(edited.FS(), "OEBPS/content.opf")
+		if !strings.Contains(string(opf), `<item id="old" href="images/old.png" media-type="image/png"/>`) {
+			t.Errorf("Unexpected package document %s", opf)
+		}
+	})
+
+	for _, oldHref := range []string{"Text/titlepage.xhtml", "cover.xhtml"} {
+		t.Run("epub2 with old cover page in the ncx "+oldHref, func(t *testing.T) {
+			data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Old Cover</dc:title><dc:identifier id="id">x</dc:identifier><dc:language>en</dc:language><meta name="cover" content="old"/></metadata>
+  <manifest>
+    <item id="old" href="images/old.png" media-type="image/png"/>
+    <item id="old-page" href="`+oldHref+`" media-type="application/xhtml+xml"/>
+    <item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+  </manifest>
+  <spine toc="ncx">
+    <itemref idref="old-page"/>
+    <itemref idref="ch1"/>
+  </spine>
+</package>`, map[string][]byte{
+				"OEBPS/images/old.png": oldCover,
+				"OEBPS/" + oldHref:     []byte(`<html><body><img src="` + relativeHref("OEBPS/"+oldHref, "OEBPS/images/old.png") + `"/></body></html>`),
+				"OEBPS/ch1.xhtml":      chapter,
+				"OEBPS/toc.ncx": []byte(`<?xml version="1.0"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1"><navMap>
+<navPoint id="p1"><navLabel><text>Cover</text></navLabel><content src="` + oldHref + `#top"/></navPoint>
+<navPoint id="p2"><navLabel><text>Chapter</text></navLabel><content src="ch1.xhtml"/></navPoint>
+</navMap></ncx>`),
+			})
+			epub, _ := ReadEpub(bytes.NewReader(data), nil)
+			editor, err := epub.Edit()
+			if err != nil {
+				t.Fatalf("Edit failed: %v", err)
+			}
+			if err := editor.SetCover(newCover, "", &CoverOptions{CoverPage: true}); err != nil {
+				t.Fatalf("SetCover failed: %v", err)
+			}
+			buf := new(bytes.Buffer)
+			if err := editor.Write(buf); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			edited, err := ReadEpub(bytes.NewReader(buf.Bytes()), &eBookData.ParseOptions{Strict: true})
+			if err != nil {
+				t.Fatalf("ReadEpub of the edited epub failed: %v", err)
+			}
+			spine := edited.Spine()
+			if len(spine) != 2 || spine[0].Href != "OEBPS/cover.xhtml" || spine[1].ID != "ch1" {
+				t.Fatalf("Unexpected spine %+v", spine)
+			}
+			toc := edited.TableOfContents()
+			if len(toc) != 2 || toc[0].Href != "OEBPS/cover.xhtml" || toc[0].Fragment != "top" || toc[1].Href != "OEBPS/ch1.xhtml" {
+				t.Errorf("Unexpected table of contents %+v", toc)
+			}
+			if _, err := fs.Stat(edited.FS(), "OEBPS/Text/titlepage.xhtml"); err == nil {
+				t.Errorf("The old cover page should be removed")
+			}
+			if entries := rawZipEntries(t, buf.Bytes()); len(entries) != 7 {
+				t.Errorf("Unexpected entries %d", len(entries))
+			}
+			if findings := Validate(bytes.NewReader(buf.Bytes()), int64(buf.Len())); len(findings) != 0 {
+				t.Errorf("Unexpected findings %v", findings)
+			}
+		})
+	}
+
+	t.Run("epub2 with linked old cover page", func(t *testing.T) {
+		data := createEpub(t, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Old Cover</dc:title><dc:identifier id="id">x</dc:identifier><meta name="cover" content="old"/></metadata>
+  <manifest>
+    <item id="old" href="images/old.png" media-type="image/png"/>
+    <item id="old-page" href="cover.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="old-page"/>
+    <itemref idref="ch1"/>
+  </spine>
+</package>`, map[string][]byte{
+			"OEBPS/images/old.png": oldCover,
+			"OEBPS/cover.xhtml":    oldPage,
+			"OEBPS/ch1.xhtml":      []byte(`<html><body><p><a href="cover.xhtml">Cover</a></p></body></html>`),
+		})
+		epub, _ := ReadEpub(bytes.NewReader(data), nil)
+		edited := editCover(t, epub, newCover, &CoverOptions{CoverPage: true})
+		spine := edited.Spine()
+		if len(spine) != 3 || spine[0].Href != "OEBPS/cover-1.xhtml" || spine[1].Href != "OEBPS/cover.xhtml" {
+			t.Fatalf("The linked old cover page should be kept, got %+v", spine)
+		}
+	})
+}
+
+func editCover(t *testing.T, epub *Epub, cover []byte, opts *CoverOptions) *Epub {
+	editor, err := epub.Edit()
+	if err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+	if err := editor.SetCover(cover, "", opts); err != nil {
+		t.Fatalf("SetCover failed: %v", err)
+	}
+	buf := new(bytes.Buffer)
+	if err := editor.Write(buf); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	edited, err := ReadEpub(bytes.NewReader(buf.Bytes()), &eBookData.ParseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("ReadEpub of the edited epub failed: %v", err)
+	}
+	return edited
+}
+
+func findingCodes(findings []Finding) []FindingCode {
+	ret := make([]FindingCode, 0, len(findings))
+	for _, finding := range findings {
+		ret = append(ret, finding.Code)
+	}
+	return ret
+}
+
+func TestValidate(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := WriteEpub(buf, EpubContent{
+		Metadata: epubMetadata{title: "Valid", language: "en"},
+		Chapters: []Chapter{{Href: "ch1.xhtml", Title: "Chapter", Content: []byte("<html/>")}},
+	})
+	if err != nil {
+		t.Fatalf("WriteEpub failed: %v", err)
+	}
+	if findings := Validate(bytes.NewReader(buf.Bytes()), int64(buf.Len())); len(findings) != 0 {
+		t.Errorf("Unexpected findings of a valid epub %v", findings)
+	}
+
+	broken := new(bytes.Buffer)
+	zw := zip.NewWriter(broken)
+	files := []struct {
+		name string
+		data string
+	}{
+		{"META-INF/container.xml", `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+	<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`},
+		{"mimetype", "application/epub+zip\n"},
+		{"OEBPS/content.opf", `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="missing">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+		<dc:title>Broken</dc:title>
+		<dc:identifier id="uid">x</dc:identifier>
+	</metadata>
+	<manifest>
+		<item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+		<item id="ch1" href="Ch2.xhtml" media-type="application/xhtml+xml"/>
+		<item id="gone" href="gone.xhtml" media-type="application/xhtml+xml"/>
+		<item id="escape" href="../../outside.css" media-type="text/css"/>
+	</manifest>
+	<spine toc="ncx">
+		<itemref idref="ch1"/>
+		<itemref idref="nothing"/>
+	</spine>
+</package>`},
+		{"OEBPS/ch1.xhtml", "<html/>"},
+		{"OEBPS/ch2.xhtml", "<html/>"},
+		{"OEBPS/extra.png", "png"},
+	}
+	for _, file := range files {
+		w, _ := zw.Create(file.name)
+		io.WriteString(w, file.data)
+	}
+	zw.Close()
+	findings := Validate(bytes.NewReader(broken.Bytes()), int64(broken.Len()))
+	expected := []FindingCode{
+		CODE_MIMETYPE_NOT_FIRST, CODE_MIMETYPE_COMPRESSED, CODE_MIMETYPE_INVALID,
+		CODE_DUPLICATE_ID, CODE_LANGUAGE_MISSING, CODE_UNIQUE_ID_INVALID, CODE_MODIFIED_MISSING,
+		CODE_MANIFEST_HREF_CASE, CODE_MANIFEST_FILE_MISSING, CODE_MANIFEST_ITEM_INVALID,
+		CODE_SPINE_TOC_MISSING, CODE_SPINE_IDREF_MISSING, CODE_FILE_NOT_IN_MANIFEST,
+	}
+	if codes := findingCodes(findings); !slices.Equal(codes, expected) {
+		t.Errorf("Unexpected findings %v", findings)
+	}
+	if !HasErrors(findings) {
+		t.Errorf("HasErrors should be true")
+	}
+	last := findings[len(findings)-1]
+	if last.Severity != SEVERITY_WARNING || last.Location != "OEBPS/extra.png" {
+		t.Errorf("Unexpected unreferenced file finding %+v", last)
+	}
+
+	if codes := findingCodes(Validate(bytes.NewReader([]byte("not a zip")), 9)); !slices.Equal(codes, []FindingCode{CODE_ZIP_INVALID}) {
+		t.Errorf("Unexpected findings of a non-zip file %v", codes)
+	}
+	noContainer := createEpub(t, "", map[string][]byte{"META-INF/container.xml": nil})
+	if codes := findingCodes(Validate(bytes.NewReader(noContainer), int64(len(noContainer)))); !slices.Contains(codes, CODE_CONTAINER_INVALID) {
+		t.Errorf("Unexpected findings of an invalid container %v", codes)
+	}
+
+	// the extra field of the first file is not the extra field of the mimetype
+	moved := new(bytes.Buffer)
+	zw = zip.NewWriter(moved)
+	w, _ := zw.CreateHeader(&zip.FileHeader{Name: "META-INF/container.xml", Method: zip.Deflate, Extra: []byte{0xfe, 0xca, 0, 0}})
+	w.Write(containerXml("OEBPS/content.opf"))
+	w, _ = zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	io.WriteString(w, EPUB_MIMETYPE)
+	zw.Close()
+	if codes := findingCodes(Validate(bytes.NewReader(moved.Bytes()), int64(moved.Len()))); !slices.Contains(codes, CODE_MIMETYPE_NOT_FIRST) || slices.Contains(codes, CODE_MIMETYPE_EXTRA_FIELD) {
+		t.Errorf("Unexpected findings of a mimetype after a file with extra field %v", codes)
+	}
+}
+
+func TestValidateCoverAndToc(t *testing.T) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	writeMimetype(zw)
+	files := []struct {
+		name string
+		data string
+	}{
+		{"META-INF/container.xml", string(containerXml("OEBPS/content.opf"))},
+		{"OEBPS/content.opf", `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0" unique-identifier="uid">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Links</dc:title><dc:identifier id="uid">x</dc:identifier><dc:language>en</dc:language></metadata>
+	<manifest>
+		<item id="cover" href="cover.xhtml" media-type="application/xhtml+xml"/>
+		<item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+		<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+	</manifest>
+	<spine toc="ncx"><itemref idref="cover"/><itemref idref="ch1"/></spine>
+	<guide><reference type="cover" href="cover.xhtml"/></guide>
+</package>`},
+		{"OEBPS/ch1.xhtml", "<html/>"},
+		{"OEBPS/toc.ncx", `<?xml version="1.0"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1"><navMap>
+<navPoint id="p1"><navLabel><text>Chapter</text></navLabel><content src="ch1.xhtml"/>
+<navPoint id="p2"><navLabel><text>Gone</text></navLabel><content src="gone.xhtml#top"/></navPoint></navPoint>
+</navMap></ncx>`},
+	}
+	for _, file := range files {
+		w, _ := zw.Create(file.name)
+		io.WriteString(w, file.data)
+	}
+	// unknown compression method, the cover page can't be read
+	w, _ := zw.CreateRaw(&zip.FileHeader{Name: "OEBPS/cover.xhtml", Method: 99})
+	io.WriteString(w, "<html/>")
+	zw.Close()
+
+	findings := Validate(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if codes := findingCodes(findings); !slices.Equal(codes, []FindingCode{CODE_COVER_INVALID, CODE_TOC_LINK_MISSING}) {
+		t.Fatalf("Unexpected findings %v", findings)
+	}
+	if findings[0].Severity != SEVERITY_WARNING || findings[1].Location != "OEBPS/toc.ncx" || !strings.Contains(findings[1].Message, "OEBPS/gone.xhtml") {
+		t.Errorf("Unexpected findings %v", findings)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := WriteEpub(buf, EpubContent{
+		Metadata: epubMetadata{title: "Valid", language: "en"},
+		Chapters: []Chapter{{Href: "ch1.xhtml", Title: "Chapter", Content: []byte("<html/>")}},
+	})
+	if err != nil {
+		t.Fatalf("WriteEpub failed: %v", err)
+	}
+	out := new(bytes.Buffer)
+	changes, err := Repair(bytes.NewReader(buf.Bytes()), int64(buf.Len()), out)
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("Unexpected changes of a valid epub %v %v", changes, err)
+	}
+	if before, after := rawZipEntries(t, buf.Bytes()), rawZipEntries(t, out.Bytes()); !bytes.Equal(before["OEBPS/content.opf"], after["OEBPS/content.opf"]) {
+		t.Errorf("The unchanged package document is rewritten")
+	}
+
+	image := createTestImage(t, 20, 30)
+	broken := new(bytes.Buffer)
+	zw := zip.NewWriter(broken)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"META-INF/container.xml", []byte(`<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+	<rootfiles><rootfile full-path="oebps/Content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`)},
+		{"mimetype", []byte("application/epub+zip")},
+		{"OEBPS/content.opf", []byte(`<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0" unique-identifier="uid">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+		<dc:title>Broken</dc:title>
+		<dc:identifier id="uid">x</dc:identifier>
+		<dc:language>en</dc:language>
+	</metadata>
+	<manifest>
+		<item id="cover" href="Cover.xhtml" media-type="application/xhtml+xml"/>
+		<item id="img" href="Images/Cover.JPG" media-type="image/png"/>
+	</manifest>
+	<spine>
+		<itemref idref="cover"/>
+	</spine>
+	<guide>
+		<reference type="cover" title="Cover" href="Cover.xhtml"/>
+	</guide>
+</package>`)},
+		{"OEBPS/cover.xhtml", []byte(`<html xmlns="http://www.w3.org/1999/xhtml"><body><img src="images/cover.jpg"/></body></html>`)},
+		{"OEBPS/images/cover.jpg", image},
+	}
+	for _, file := range files {
+		w, _ := zw.Create(file.name)
+		w.Write(file.data)
+	}
+	zw.Close()
+	out.Reset()
+	changes, err = Repair(bytes.NewReader(broken.Bytes()), int64(broken.Len()), out)
+	if err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	codes := make([]FindingCode, 0, len(changes))
+	for _, change := range changes {
+		codes = append(codes, change.Code)
+	}
+	expected := []FindingCode{
+		CODE_MIMETYPE_NOT_FIRST, CODE_MIMETYPE_COMPRESSED, CODE_ROOTFILE_MISSING,
+		CODE_MANIFEST_HREF_CASE, CODE_MANIFEST_HREF_CASE, CODE_COVER_META_MISSING,
+	}
+	if !slices.Equal(codes, expected) {
+		t.Errorf("Unexpected changes %v", changes)
+	}
+	if findings := Validate(bytes.NewReader(out.Bytes()), int64(out.Len())); len(findings) != 0 {
+		t.Errorf("Unexpected findings of the repaired epub %v", findings)
+	}
+	epub, err := ReadEpubAt(bytes.NewReader(out.Bytes()), int64(out.Len()), nil)
+	if err != nil {
+		t.Fatalf("Repaired epub not readable: %v", err)
+	}
+	if cover := epub.Cover(); cover == nil || cover.Source != eBookData.COVER_SOURCE_META || cover.Path != "OEBPS/images/cover.jpg" {
+		t.Errorf("Unexpected cover of the repaired epub %+v", cover)
+	}
+	if before, after := rawZipEntries(t, broken.Bytes()), rawZipEntries(t, out.Bytes()); !bytes.Equal(before["OEBPS/images/cover.jpg"], after["OEBPS/images/cover.jpg"]) {
+		t.Errorf("The cover image is not copied unchanged")
+	}
+
+	noContainer := new(bytes.Buffer)
+	zw = zip.NewWriter(noContainer)
+	writeMimetype(zw)
+	w, _ := zw.Create("content.opf")
+	w.Write(files[2].data)
+	zw.Close()
+	out.Reset()
+	changes, err = Repair(bytes.NewReader(noContainer.Bytes()), int64(noContainer.Len()), out)
+	if err != nil || len(changes) == 0 || changes[0].Code != CODE_CONTAINER_MISSING {
+		t.Fatalf("Unexpected changes of an epub without container %v %v", changes, err)
+	}
+	if epub, err := ReadEpubAt(bytes.NewReader(out.Bytes()), int64(out.Len()), nil); err != nil || epub.Rendition().FullPath != "content.opf" {
+		t.Errorf("Epub with new container not readable: %v", err)
+	}
+}
+
+func TestReadEpubEncryption(t *testing.T) {
+	encryptionXml := func(algorithm string, keyInfo string, uris ...string) []byte {
+		data := `<?xml version="1.0"?>
+<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#">`
+		for _, uri := range uris {
+			data += `<enc:EncryptedData><enc:EncryptionMethod Algorithm="` + algorithm + `"/>` + keyInfo +
+				`<enc:CipherData><enc:CipherReference URI="` + uri + `"/></enc:CipherData></enc:EncryptedData>`
+		}
+		return []byte(data + `</encryption>`)
+	}
+	rightsXml := func(key string) []byte {
+		return []byte(`<?xml version="1.0"?>
+<adept:rights xmlns:adept="http://ns.adobe.com/adept"><adept:licenseToken>` + key + `</adept:licenseToken></adept:rights>`)
+	}
+	aes := "http://www.w3.org/2001/04/xmlenc#aes128-cbc"
+	adeptKey := `<KeyInfo xmlns="http://www.w3.org/2000/09/xmldsig#"><resource xmlns="http://ns.adobe.com/adept">urn:uuid:1</resource></KeyInfo>`
+	lcpKey := `<ds:KeyInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:RetrievalMethod URI="license.lcpl#/encryption/content_key" Type="http://readium.org/2014/01/lcp#EncryptedContentKey"/></ds:KeyInfo>`
+	tests := []struct {
+		name      string
+		files     map[string][]byte
+		scheme    eBookData.EncryptionScheme
+		resources int
+	}{
+		{"none", map[string][]byte{}, eBookData.ENCRYPTION_NONE, 0},
+		{"font", map[string][]byte{"META-INF/encryption.xml": encryptionXml(IDPF_FONT_ALGORITHM, "", "OEBPS/fonts/a%20b.otf")},
+			eBookData.ENCRYPTION_FONT_OBFUSCATION, 1},
+		{"adept", map[string][]byte{
+			"META-INF/encryption.xml": encryptionXml(aes, adeptKey, "OEBPS/ch1.xhtml", "OEBPS/cover.jpg"),
+			"META-INF/rights.xml":     rightsXml(`<adept:encryptedKey>` + strings.Repeat("A", 172) + `</adept:encryptedKey>`),
+		}, eBookData.ENCRYPTION_ADOBE_ADEPT, 2},
+		{"barnes-noble", map[string][]byte{
+			"META-INF/encryption.xml": encryptionXml(aes, adeptKey, "OEBPS/ch1.xhtml"),
+			"META-INF/rights.xml":     rightsXml(`<adept:encryptedKey keyType="passhash">` + strings.Repeat("A", 64) + `</adept:encryptedKey>`),
+		}, eBookData.ENCRYPTION_BARNES_NOBLE, 1},
+		{"lcp", map[string][]byte{
+			"META-INF/encryption.xml": encryptionXml(aes, lcpKey, "OEBPS/ch1.xhtml"),
+			"META-INF/license.lcpl":   []byte("{}"),
+		}, eBookData.ENCRYPTION_READIUM_LCP, 1},
+		{"fairplay", map[string][]byte{
+			"META-INF/encryption.xml": encryptionXml(FAIRPLAY_ALGORITHM, "", "OEBPS/ch1.xhtml"),
+			"META-INF/sinf.xml":       []byte("<fairplay:sinf xmlns:fairplay=\"http://itunes.apple.com/ns/epub\"/>"),
+		}, eBookData.ENCRYPTION_APPLE_FAIRPLAY, 1},
+		{"unknown", map[string][]byte{"META-INF/encryption.xml": encryptionXml(aes, "", "OEBPS/ch1.xhtml")}, eBookData.ENCRYPTION_UNKNOWN, 1},
+	}
+	opf := `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Locked</dc:title></metadata>
+	<manifest>
+		<item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+		<item id="img" href="cover.jpg" media-type="image/jpeg" properties="cover-image"/>
+	</manifest>
+	<spine><itemref idref="ch1"/></spine>
+</package>`
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.files["OEBPS/ch1.xhtml"] = []byte("<html/>")
+			test.files["OEBPS/cover.jpg"] = createTestImage(t, 10, 10)
+			book, err := ReadEpub(bytes.NewReader(createEpub(t, opf, test.files)), nil)
+			if err != nil {
+				t.Fatalf("ReadEpub failed: %v", err)
+			}
+			encrypted, ok := eBookData.Book(book).(eBookData.Encrypted)
+			if !ok {
+				t.Fatalf("Epub is not Encrypted")
+			}
+			encryption := encrypted.Encryption()
+			if encryption.Scheme != test.scheme || len(encryption.Resources) != test.resources {
+				t.Errorf("Unexpected encryption %+v", encryption)
+			}
+			drm := test.scheme != eBookData.ENCRYPTION_NONE && test.scheme != eBookData.ENCRYPTION_FONT_OBFUSCATION
+			if encryption.IsDRM() != drm || encryption.IsEncrypted("OEBPS/ch1.xhtml") != drm {
+				t.Errorf("Unexpected DRM state of %+v", encryption)
+			}
+			if (book.Cover() == nil) != encryption.IsEncrypted("OEBPS/cover.jpg") {
+				t.Errorf("The encrypted cover should be skipped, got %v", book.Cover())
+			}
+		})
+	}
+
+	font := createEpub(t, opf, map[string][]byte{"META-INF/encryption.xml": encryptionXml(IDPF_FONT_ALGORITHM, "", "OEBPS/fonts/a%20b.otf")})
+	book, _ := ReadEpub(bytes.NewReader(font), nil)
+	if resources := book.Encryption().Resources; len(resources) != 1 || resources[0].Path != "OEBPS/fonts/a b.otf" || !resources[0].Obfuscated {
+		t.Errorf("Unexpected obfuscated font %+v", resources)
+	}
+	invalid := createEpub(t, opf, map[string][]byte{"META-INF/encryption.xml": []byte("<encryption")})
+	if _, err := ReadEpub(bytes.NewReader(invalid), &eBookData.ParseOptions{Strict: true}); err == nil {
+		t.Errorf("Invalid encryption.xml should fail in strict mode")
+	}
+	if book, err := ReadEpub(bytes.NewReader(invalid), nil); err != nil || book.Encryption().Scheme != eBookData.ENCRYPTION_UNKNOWN {
+		t.Errorf("Invalid encryption.xml should be unknown encryption, got %v", err)
+	}
+}
+
+func TestReadEpubLogger(t *testing.T) {
+	global := new(bytes.Buffer)
+	saved := log.Logger
+	log.Logger = zerolog.New(global).Level(zerolog.TraceLevel)
+	t.Cleanup(func() { log.Logger = saved })
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	w, _ := zw.Create("META-INF/container.xml")
+	io.WriteString(w, `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+	<rootfiles>
+		<rootfile full-path="content.opf" media-type="application/oebps-package+xml"/>
+	</rootfiles>
+</container>`)
+	w, _ = zw.Create("content.opf")
+	io.WriteString(w, `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="BookId">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+		<dc:title>Logged</dc:title>
+	</metadata>
+	<manifest/>
+</package>`)
+	zw.Close()
+
+	out := new(bytes.Buffer)
+	logger := zerolog.New(out).Level(zerolog.TraceLevel)
+	epub, err := ReadEpub(bytes.NewReader(buf.Bytes()), &eBookData.ParseOptions{Logger: &logger})
+	if err != nil {
+		t.Fatalf("ReadEpub failed: %v", err)
+	}
+	editor, err := epub.Edit()
+	if err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+	if err := editor.SetCover(createTestImage(t, 4, 6), "", nil); err != nil {
+		t.Fatalf("SetCover failed: %v", err)
+	}
+	for _, message := range []string{"Title parsed", "Cover set"} {
+		if !strings.Contains(out.String(), message) {
+			t.Errorf("The message %q should be logged to the logger of the options\n%s", message, out)
+		}
+	}
+	if global.Len() != 0 {
+		t.Errorf("The global logger should not be used\n%s", global)
 	}
 }
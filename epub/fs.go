@@ -0,0 +1,160 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+
+	"github.com/rs/zerolog"
+)
+
+// FileInfo is the fs.FileInfo of a file in the epub, extended with the media type of the manifest
+type FileInfo struct {
+	fs.FileInfo
+	mediaType string
+}
+
+// MediaType returns the media type of the manifest item, or empty for the files that are not in the manifest
+func (i FileInfo) MediaType() string {
+	return i.mediaType
+}
+
+// manifestMediaTypes maps the names of the manifest items in the zip to their media type
+func manifestMediaTypes(manifest []manifestItem, opfPath string) map[string]string {
+	ret := make(map[string]string)
+	for _, item := range manifest {
+		name := resolveHref(opfPath, item.href)
+		if name == "" {
+			continue
+		}
+		if _, ok := ret[name]; !ok {
+			ret[name] = item.mediaType
+		}
+	}
+	return ret
+}
+
+// epubFS is the read only fs.FS view of the container
+type epubFS struct {
+	zip        *zip.Reader
+	mediaTypes map[string]string
+	logger     *zerolog.Logger
+}
+
+// FS returns the files of the container as an fs.FS. The names are the paths in the zip, eg. "OEBPS/images/cover.jpg".
+// Names with "..", leading "/" or other invalid parts are rejected with fs.ErrInvalid, so the files can not escape the container.
+// The fs.FileInfo of the files is a FileInfo with the media type of the manifest.
+// The reader the epub was read from has to be still open.
+func (epub Epub) FS() fs.FS {
+	return epubFS{zip: epub.zip, mediaTypes: epub.mediaTypes, logger: epub.log()}
+}
+
+func (e epubFS) mediaType(name string) string {
+	return e.mediaTypes[name]
+}
+
+func (e epubFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		e.logger.Trace().Str("Name", name).Msg("Invalid path")
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if e.zip == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	file, err := e.zip.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &epubFile{File: file, fs: e, name: name}, nil
+}
+
+func (e epubFS) Stat(name string) (fs.FileInfo, error) {
+	file, err := e.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return file.Stat()
+}
+
+func (e epubFS) ReadFile(name string) ([]byte, error) {
+	file, err := e.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// epubFile wraps the file of the zip to return FileInfo and to be seekable, that is needed by http.FS.
+// The compressed files can not seek, so the content is read into memory at the first Seek.
+type epubFile struct {
+	fs.File
+	fs     epubFS
+	name   string
+	offset int64
+	data   *bytes.Reader
+}
+
+func (f *epubFile) Stat() (fs.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return FileInfo{FileInfo: info, mediaType: f.fs.mediaType(f.name)}, nil
+}
+
+func (f *epubFile) Read(p []byte) (int, error) {
+	if f.data != nil {
+		return f.data.Read(p)
+	}
+	n, err := f.File.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *epubFile) Seek(offset int64, whence int) (int64, error) {
+	if f.data == nil {
+		file, err := f.fs.zip.Open(f.name)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return 0, &fs.PathError{Op: "seek", Path: f.name, Err: err}
+		}
+		f.data = bytes.NewReader(data)
+		if _, err := f.data.Seek(f.offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+	return f.data.Seek(offset, whence)
+}
+
+func (f *epubFile) ReadDir(count int) ([]fs.DirEntry, error) {
+	dir, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: fs.ErrInvalid}
+	}
+	entries, err := dir.ReadDir(count)
+	for i, entry := range entries {
+		entries[i] = dirEntry{DirEntry: entry, mediaType: f.fs.mediaType(path.Join(f.name, entry.Name()))}
+	}
+	return entries, err
+}
+
+type dirEntry struct {
+	fs.DirEntry
+	mediaType string
+}
+
+func (d dirEntry) Info() (fs.FileInfo, error) {
+	info, err := d.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return FileInfo{FileInfo: info, mediaType: d.mediaType}, nil
+}
@@ -0,0 +1,67 @@
+package epub
+
+import (
+	"archive/zip"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// resolveHref returns the zip name of an href found in the file base (eg. the OPF or an XHTML page).
+// The fragment and the query are dropped and the percent-encoding is decoded.
+// Returns empty string for external links and for paths outside of the container.
+func resolveHref(base string, href string) string {
+	href = strings.TrimSpace(href)
+	if i := strings.IndexAny(href, "#?"); i >= 0 {
+		href = href[:i]
+	}
+	if href == "" {
+		return ""
+	}
+	if u, err := url.Parse(href); err == nil && u.Scheme != "" {
+		return ""
+	}
+	decoded, err := url.PathUnescape(href)
+	if err != nil {
+		decoded = href
+	}
+	var name string
+	if strings.HasPrefix(decoded, "/") {
+		name = path.Clean(strings.TrimPrefix(decoded, "/"))
+	} else {
+		name = path.Join(path.Dir(base), decoded)
+	}
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return ""
+	}
+	return name
+}
+
+// hrefFragment returns the fragment of an href without the #
+func hrefFragment(href string) string {
+	if i := strings.Index(href, "#"); i >= 0 {
+		return href[i+1:]
+	}
+	return ""
+}
+
+// lookupHref finds the zip file of an href found in the file base.
+// Some packagers store the names percent-encoded, so the raw form is also checked.
+func lookupHref(files map[string]*zip.File, base string, href string) (*zip.File, string) {
+	name := resolveHref(base, href)
+	if name == "" {
+		return nil, ""
+	}
+	if file := files[name]; file != nil {
+		return file, name
+	}
+	raw := href
+	if i := strings.IndexAny(raw, "#?"); i >= 0 {
+		raw = raw[:i]
+	}
+	rawName := path.Join(path.Dir(base), raw)
+	if file := files[rawName]; file != nil {
+		return file, rawName
+	}
+	return nil, name
+}
@@ -0,0 +1,68 @@
+package epub
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/rs/zerolog"
+)
+
+// manifestItem is an <item> of the OPF manifest, href is as written in the OPF, use resolveHref to get the zip name
+type manifestItem struct {
+	id         string
+	href       string
+	mediaType  string
+	properties []string
+}
+
+func (m manifestItem) hasProperty(property string) bool {
+	return slices.Contains(m.properties, property)
+}
+func (m manifestItem) isImage() bool {
+	return strings.HasPrefix(m.mediaType, "image/")
+}
+func (m manifestItem) isXhtml() bool {
+	return m.mediaType == "application/xhtml+xml" || m.mediaType == "text/html"
+}
+
+func parseManifest(doc *xmlquery.Node, nsMap map[string]string, logger *zerolog.Logger) []manifestItem {
+	ret := make([]manifestItem, 0)
+	expr, err := xpath.CompileWithNS("/opf:package/opf:manifest/opf:item", nsMap)
+	if err != nil {
+		panic(err)
+	}
+	for _, node := range xmlquery.QuerySelectorAll(doc, expr) {
+		item := manifestItem{
+			id:         node.SelectAttr("id"),
+			href:       node.SelectAttr("href"),
+			mediaType:  strings.ToLower(strings.TrimSpace(node.SelectAttr("media-type"))),
+			properties: strings.Fields(node.SelectAttr("properties")),
+		}
+		if item.href == "" {
+			continue
+		}
+		ret = append(ret, item)
+	}
+	logger.Trace().Int("Item qrt", len(ret)).Msg("Manifest parsed")
+	return ret
+}
+
+func findManifestItem(manifest []manifestItem, match func(manifestItem) bool) (manifestItem, bool) {
+	idx := slices.IndexFunc(manifest, match)
+	if idx < 0 {
+		return manifestItem{}, false
+	}
+	return manifest[idx], true
+}
+
+// parseSpine returns the idrefs of the spine in reading order
+func parseSpine(doc *xmlquery.Node, nsMap map[string]string) []string {
+	ret := make([]string, 0)
+	expr, _ := xpath.CompileWithNS("/opf:package/opf:spine/opf:itemref/@idref", nsMap)
+	for _, node := range xmlquery.QuerySelectorAll(doc, expr) {
+		ret = append(ret, node.InnerText())
+	}
+	return ret
+}
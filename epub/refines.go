@@ -0,0 +1,120 @@
+package epub
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/rs/zerolog"
+)
+
+// refinements are the EPUB3 <meta refines="#id" property="..."> values: id -> property -> values
+type refinements map[string]map[string][]string
+
+func (r refinements) get(id string, property string) []string {
+	if id == "" {
+		return nil
+	}
+	return r[id][property]
+}
+func (r refinements) first(id string, property string) string {
+	values := r.get(id, property)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func parseRefines(doc *xmlquery.Node, nsMap map[string]string, logger *zerolog.Logger) (refinements, error) {
+	ret := make(refinements)
+	expr, err := xpath.CompileWithNS("/opf:package/opf:metadata/opf:meta[@refines]", nsMap)
+	if err != nil {
+		panic(err)
+	}
+	for _, node := range xmlquery.QuerySelectorAll(doc, expr) {
+		id := strings.TrimPrefix(strings.TrimSpace(node.SelectAttr("refines")), "#")
+		property := strings.TrimSpace(node.SelectAttr("property"))
+		value := strings.TrimSpace(node.InnerText())
+		if id == "" || property == "" || value == "" {
+			continue
+		}
+		if ret[id] == nil {
+			ret[id] = make(map[string][]string)
+		}
+		ret[id][property] = append(ret[id][property], value)
+		logger.Trace().Str("Id", id).Str("Property", property).Str("Value", value).Msg("Refinement parsed")
+	}
+	return ret, nil
+}
+
+// parseTitle returns the main title and the subtitle. Without EPUB3 title-type refinements the first dc:title is the title.
+func parseTitle(doc *xmlquery.Node, nsMap map[string]string, refines refinements, logger *zerolog.Logger) (string, string, error) {
+	expr, _ := xpath.CompileWithNS("/opf:package/opf:metadata/dc:title", nsMap)
+	nodes := xmlquery.QuerySelectorAll(doc, expr)
+	var title, subtitle, first string
+	for _, node := range nodes {
+		value := strings.TrimSpace(node.InnerText())
+		if value == "" {
+			continue
+		}
+		if first == "" {
+			first = value
+		}
+		switch refines.first(node.SelectAttr("id"), "title-type") {
+		case "main":
+			if title == "" {
+				title = value
+			}
+		case "subtitle":
+			if subtitle == "" {
+				subtitle = value
+			}
+		}
+	}
+	if title == "" {
+		title = first
+	}
+	if title == "" {
+		logger.Trace().Msg("No title found")
+		return "", "", nil
+	}
+	logger.Trace().Str("Title", title).Str("Subtitle", subtitle).Msg("Title parsed")
+	return title, subtitle, nil
+}
+
+// parseCollections reads the EPUB3 belongs-to-collection metas with their collection-type and group-position refinements
+func parseCollections(doc *xmlquery.Node, nsMap map[string]string, refines refinements, logger *zerolog.Logger) ([]eBookData.Collection, error) {
+	ret := make([]eBookData.Collection, 0)
+	expr, _ := xpath.CompileWithNS("/opf:package/opf:metadata/opf:meta[@property='belongs-to-collection' and not(@refines)]", nsMap)
+	for _, node := range xmlquery.QuerySelectorAll(doc, expr) {
+		collection := eBookData.Collection{Name: strings.TrimSpace(node.InnerText())}
+		if collection.Name == "" {
+			continue
+		}
+		id := node.SelectAttr("id")
+		collection.Type = refines.first(id, "collection-type")
+		if position := refines.first(id, "group-position"); position != "" {
+			if value, err := strconv.ParseFloat(position, 64); err == nil {
+				collection.Position = value
+			} else {
+				logger.Trace().Str("Position", position).Msg("Invalid group position")
+			}
+		}
+		logger.Trace().Str("Name", collection.Name).Str("Type", collection.Type).Float64("Position", collection.Position).Msg("Collection parsed")
+		ret = append(ret, collection)
+	}
+	return ret, nil
+}
+
+// seriesCollection returns the first collection with series type. A collection without type is not a series,
+// eg. calibre writes the series as calibre:series and may add other collections too.
+func seriesCollection(collections []eBookData.Collection) (eBookData.Collection, bool) {
+	if idx := slices.IndexFunc(collections, func(c eBookData.Collection) bool { return c.Type == "series" }); idx >= 0 {
+		return collections[idx], true
+	}
+	return eBookData.Collection{}, false
+}
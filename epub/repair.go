@@ -0,0 +1,267 @@
+package epub
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// RepairChange is a change made by Repair. The code is the code of the finding of Validate that the change fixes.
+type RepairChange struct {
+	Code FindingCode
+	// Location is the name of the changed file in the zip
+	Location string
+	Message  string
+}
+
+func (c RepairChange) String() string {
+	return fmt.Sprintf("%s %s: %s", c.Code, c.Location, c.Message)
+}
+
+type repairer struct {
+	files   map[string]*zip.File
+	changes []RepairChange
+	// container is the new content of the container, nil if it is not changed
+	container []byte
+	// oldContainer is the container stored under a name in other case, it is left out
+	oldContainer string
+	logger       *zerolog.Logger
+}
+
+func (r *repairer) add(code FindingCode, location string, format string, args ...any) {
+	change := RepairChange{Code: code, Location: location, Message: fmt.Sprintf(format, args...)}
+	r.logger.Trace().Str("Change", change.String()).Msg("Repair change")
+	r.changes = append(r.changes, change)
+}
+
+// findPackage returns the first .opf file of the zip by name
+func (r *repairer) findPackage() string {
+	names := make([]string, 0)
+	for name := range r.files {
+		if strings.HasSuffix(strings.ToLower(name), ".opf") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	slices.Sort(names)
+	return names[0]
+}
+
+// repairContainer returns the package document of the first rootfile. The container is looked up ignoring the case of the name,
+// the rootfiles that differ in case are corrected. If no rootfile can be used, a new container is made with the first .opf file of the zip.
+func (r *repairer) repairContainer() string {
+	file := r.files[CONTAINER_PATH]
+	if file == nil {
+		if name := findFileIgnoreCase(r.files, CONTAINER_PATH); name != "" {
+			file = r.files[name]
+			r.oldContainer = name
+		}
+	}
+	var doc *xmlquery.Node
+	if file != nil {
+		doc, _ = readXml(file)
+	}
+	var rootfiles []*xmlquery.Node
+	if doc != nil {
+		expr, _ := xpath.CompileWithNS("/c:container/c:rootfiles/c:rootfile", containerNsMap)
+		rootfiles = xmlquery.QuerySelectorAll(doc, expr)
+		if len(rootfiles) == 0 {
+			expr, _ = xpath.Compile("/container/rootfiles/rootfile")
+			rootfiles = xmlquery.QuerySelectorAll(doc, expr)
+		}
+	}
+
+	opfPath := ""
+	changed := r.oldContainer != ""
+	for _, node := range rootfiles {
+		fullPath := strings.TrimPrefix(strings.TrimSpace(node.SelectAttr("full-path")), "/")
+		if mediaType := strings.TrimSpace(node.SelectAttr("media-type")); fullPath == "" || (mediaType != "" && mediaType != PACKAGE_MEDIA_TYPE) {
+			continue
+		}
+		if r.files[fullPath] == nil {
+			name := findFileIgnoreCase(r.files, fullPath)
+			if name == "" {
+				continue
+			}
+			node.SetAttr("full-path", name)
+			changed = true
+			r.add(CODE_ROOTFILE_MISSING, CONTAINER_PATH, "The rootfile %s is changed to %s", fullPath, name)
+			fullPath = name
+		}
+		if opfPath == "" {
+			opfPath = fullPath
+		}
+	}
+	if opfPath != "" {
+		if changed {
+			r.container = []byte(doc.OutputXMLWithOptions(xmlquery.WithEmptyTagSupport(), xmlquery.WithPreserveSpace()))
+		}
+		if r.oldContainer != "" {
+			r.add(CODE_CONTAINER_MISSING, CONTAINER_PATH, "The container is renamed from %s", r.oldContainer)
+		}
+		return opfPath
+	}
+
+	opfPath = r.findPackage()
+	if opfPath == "" {
+		return ""
+	}
+	r.container = containerXml(opfPath)
+	code := CODE_ROOTFILE_MISSING
+	switch {
+	case file == nil:
+		code = CODE_CONTAINER_MISSING
+	case len(rootfiles) == 0:
+		code = CODE_CONTAINER_INVALID
+	}
+	r.add(code, CONTAINER_PATH, "The container is rewritten with the package document %s", opfPath)
+	return opfPath
+}
+
+// relativeHref returns the path of the zip name relative to the folder of the file base
+func relativeHref(base string, name string) string {
+	dir := path.Dir(base)
+	if dir == "." {
+		return name
+	}
+	from, to := strings.Split(dir, "/"), strings.Split(name, "/")
+	i := 0
+	for i < len(from) && i < len(to)-1 && from[i] == to[i] {
+		i++
+	}
+	return strings.Repeat("../", len(from)-i) + strings.Join(to[i:], "/")
+}
+
+// repairHrefCase changes the manifest hrefs that differ in case from the file in the zip
+func (r *repairer) repairHrefCase(e *Editor) bool {
+	changed := false
+	for _, node := range e.selectAll("/opf:package/opf:manifest/opf:item") {
+		href := node.SelectAttr("href")
+		if href == "" || strings.Contains(href, "://") {
+			continue
+		}
+		file, name := lookupHref(r.files, e.opfPath, href)
+		if file != nil || name == "" {
+			continue
+		}
+		actual := findFileIgnoreCase(r.files, name)
+		if actual == "" {
+			continue
+		}
+		newHref := hrefEscape(relativeHref(e.opfPath, actual))
+		node.SetAttr("href", newHref)
+		changed = true
+		r.add(CODE_MANIFEST_HREF_CASE, e.opfPath, "The href %q of the manifest item %q is changed to %q", href, node.SelectAttr("id"), newHref)
+	}
+	return changed
+}
+
+// repairCoverMeta adds the EPUB2 <meta name="cover"> to the cover image found by the other cover definitions.
+// The unreadable cover definitions are skipped.
+func (r *repairer) repairCoverMeta(e *Editor) bool {
+	cover, err := findCover(e.doc, opfNsMap, e.opfPath, r.files, r.logger)
+	if err != nil {
+		r.logger.Trace().Err(err).Msg("Cover definition not readable")
+	}
+	if cover.href == "" || cover.source == eBookData.COVER_SOURCE_META || !strings.HasPrefix(cover.mediaType, "image/") {
+		return false
+	}
+	item := e.manifestItem(cover.href)
+	if item == nil || item.SelectAttr("id") == "" {
+		return false
+	}
+	id := item.SelectAttr("id")
+	e.replace(e.selectAll("/opf:package/opf:metadata/opf:meta[@name='cover']"), []*xmlquery.Node{e.newMeta("", "name", "cover", "content", id)})
+	r.add(CODE_COVER_META_MISSING, e.opfPath, "The cover meta is added for the manifest item %q of %s found by %s", id, cover.href, cover.source)
+	return true
+}
+
+// Repair writes a fixed copy of the epub to w and returns the changes made. The fixed problems:
+//   - the mimetype is written as the first file, stored, without extra field and with the right content
+//   - the container is found in other case, the rootfile paths are corrected, or a new container is made for the .opf file of the zip
+//   - the manifest hrefs that differ in case from the file are changed to the name of the file
+//   - the EPUB2 cover meta is added, if the cover is found by the other cover definitions
+//
+// Every other entry is copied without recompression. The package document is only rewritten if it is changed,
+// then the EPUB3 modification date is updated as well.
+func Repair(r io.ReaderAt, size int64, w io.Writer) ([]RepairChange, error) {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, createEpubFormatError(err)
+	}
+	rep := &repairer{files: make(map[string]*zip.File), logger: &log.Logger}
+	for _, file := range zipReader.File {
+		rep.files[file.Name] = file
+	}
+	if len(zipReader.File) == 0 {
+		return nil, createCustomEpubFormatError("Empty zip file")
+	}
+	v := &validator{r: r, zip: zipReader, files: rep.files, logger: &log.Logger}
+	v.validateMimetype()
+	for _, finding := range v.findings {
+		rep.add(finding.Code, "mimetype", "%s, the mimetype is rewritten", finding.Message)
+	}
+
+	opfPath := rep.repairContainer()
+	if opfPath == "" {
+		return nil, createCustomEpubFormatError("No package document")
+	}
+	epub := &Epub{zip: zipReader, files: rep.files, rendition: eBookData.Rendition{FullPath: opfPath, MediaType: PACKAGE_MEDIA_TYPE}}
+	editor, err := epub.Edit()
+	if err != nil {
+		return nil, err
+	}
+	hrefs := rep.repairHrefCase(editor)
+	cover := rep.repairCoverMeta(editor)
+	editor.keepOpf = !hrefs && !cover
+	if rep.oldContainer != "" {
+		editor.removeFile(rep.oldContainer)
+	}
+	switch {
+	case rep.container == nil:
+	case rep.files[CONTAINER_PATH] != nil:
+		editor.replaced[CONTAINER_PATH] = rep.container
+	default:
+		editor.addFile(CONTAINER_PATH, rep.container)
+	}
+	if err := editor.Write(w); err != nil {
+		return nil, err
+	}
+	rep.logger.Trace().Int("Changes qrt", len(rep.changes)).Msg("Epub repaired")
+	return rep.changes, nil
+}
+
+// RepairFile writes the fixed copy of the epub file name to output, see Repair. output has to be a different file.
+func RepairFile(name string, output string) ([]RepairChange, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, createEpubFormatError(err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, createEpubFormatError(err)
+	}
+	out, err := os.Create(output)
+	if err != nil {
+		return nil, createEpubFormatError(err)
+	}
+	changes, err := Repair(f, stat.Size(), out)
+	if closeErr := out.Close(); err == nil && closeErr != nil {
+		return nil, createEpubFormatError(closeErr)
+	}
+	return changes, err
+}
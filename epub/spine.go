@@ -0,0 +1,72 @@
+package epub
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/rs/zerolog"
+)
+
+// SpineItem is a content document in the reading order
+type SpineItem struct {
+	ID string
+	// Href is the name of the file in the container
+	Href      string
+	MediaType string
+	// Linear is false for the auxiliary content (linear="no"), eg. footnotes
+	Linear bool
+	// Properties are the properties of the manifest item and the itemref, eg. "scripted", "page-spread-left"
+	Properties []string
+}
+
+// parseSpineItems returns the spine in reading order, itemrefs without manifest item are skipped
+func parseSpineItems(doc *xmlquery.Node, nsMap map[string]string, opfPath string, manifest []manifestItem, logger *zerolog.Logger) []SpineItem {
+	ret := make([]SpineItem, 0)
+	expr, _ := xpath.CompileWithNS("/opf:package/opf:spine/opf:itemref", nsMap)
+	for _, node := range xmlquery.QuerySelectorAll(doc, expr) {
+		idref := node.SelectAttr("idref")
+		item, ok := findManifestItem(manifest, func(m manifestItem) bool { return m.id == idref })
+		if !ok {
+			logger.Trace().Str("Idref", idref).Msg("No manifest item for the itemref")
+			continue
+		}
+		spineItem := SpineItem{
+			ID:         item.id,
+			Href:       resolveHref(opfPath, item.href),
+			MediaType:  item.mediaType,
+			Linear:     strings.TrimSpace(node.SelectAttr("linear")) != "no",
+			Properties: append(append([]string{}, item.properties...), strings.Fields(node.SelectAttr("properties"))...),
+		}
+		if spineItem.Href == "" {
+			continue
+		}
+		ret = append(ret, spineItem)
+	}
+	logger.Trace().Int("Item qrt", len(ret)).Msg("Spine parsed")
+	return ret
+}
+
+// Spine returns the content documents in reading order
+func (epub Epub) Spine() []SpineItem {
+	return epub.spine
+}
+
+// OpenItem opens a content document of the spine. The reader the epub was read from has to be still open.
+func (epub Epub) OpenItem(item SpineItem) (io.ReadCloser, error) {
+	if epub.files == nil {
+		return nil, createCustomEpubFormatError("Epub content not available")
+	}
+	// the Href is decoded, lookupHref finds the percent-encoded zip names too
+	file, _ := lookupHref(epub.files, "", (&url.URL{Path: item.Href}).EscapedPath())
+	if file == nil {
+		return nil, createCustomEpubFormatError("No content file " + item.Href)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return nil, createEpubFormatError(err)
+	}
+	return reader, nil
+}
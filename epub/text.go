@@ -0,0 +1,203 @@
+package epub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
+
+	"golang.org/x/net/html"
+)
+
+// TextOptions controls the plain text extraction of WriteText
+type TextOptions struct {
+	// IncludeNonLinear writes the linear="no" spine items too, eg. footnotes
+	IncludeNonLinear bool
+	// ChapterMarker returns the line written before every content document, the default is DefaultChapterMarker.
+	// title is the label from the table of contents, or empty if the document is not in it.
+	ChapterMarker func(index int, item SpineItem, title string) string
+}
+
+// DefaultChapterMarker writes the title of the chapter, or the name of the file if there is no title
+func DefaultChapterMarker(index int, item SpineItem, title string) string {
+	if title == "" {
+		title = path.Base(item.Href)
+	}
+	return fmt.Sprintf("=== %d: %s ===", index+1, title)
+}
+
+func (o *TextOptions) includeNonLinear() bool {
+	return o != nil && o.IncludeNonLinear
+}
+
+func (o *TextOptions) chapterMarker(index int, item SpineItem, title string) string {
+	if o == nil || o.ChapterMarker == nil {
+		return DefaultChapterMarker(index, item, title)
+	}
+	return o.ChapterMarker(index, item, title)
+}
+
+// elements whose content is not part of the text
+var skippedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "template": true, "noscript": true, "title": true,
+}
+
+// elements that start a new paragraph, the value is the number of line breaks around them
+var blockElements = map[string]int{
+	"p": 2, "h1": 2, "h2": 2, "h3": 2, "h4": 2, "h5": 2, "h6": 2, "blockquote": 2, "pre": 2, "table": 2, "figure": 2, "hr": 2,
+	"section": 2, "article": 2, "aside": 2, "header": 2, "footer": 2, "nav": 2, "ul": 2, "ol": 2, "dl": 2,
+	"div": 1, "li": 1, "tr": 1, "dt": 1, "dd": 1, "figcaption": 1, "caption": 1, "address": 1,
+}
+
+// textWriter collapses the whitespace of the text and writes the pending line breaks only before the next text,
+// so there are no empty paragraphs and no trailing whitespace
+type textWriter struct {
+	w            *bufio.Writer
+	pendingBreak int
+	space        bool
+	lineStarted  bool
+	started      bool
+}
+
+func (t *textWriter) lineBreak(count int) {
+	if !t.started {
+		return
+	}
+	if count > t.pendingBreak {
+		t.pendingBreak = count
+	}
+	t.space = false
+}
+
+func (t *textWriter) writeRune(r rune) {
+	if t.pendingBreak > 0 {
+		t.w.WriteString(strings.Repeat("\n", t.pendingBreak))
+		t.pendingBreak = 0
+		t.lineStarted = false
+	} else if t.space && t.lineStarted {
+		t.w.WriteByte(' ')
+	}
+	t.space = false
+	t.w.WriteRune(r)
+	t.lineStarted = true
+	t.started = true
+}
+
+func (t *textWriter) writeText(text string) {
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			t.space = true
+			continue
+		}
+		t.writeRune(r)
+	}
+}
+
+// writePre keeps the line breaks and the spaces of preformatted text
+func (t *textWriter) writePre(text string) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	for _, line := range strings.SplitAfter(text, "\n") {
+		content := strings.TrimSuffix(line, "\n")
+		if content != "" {
+			first, size := utf8.DecodeRuneInString(content)
+			t.writeRune(first)
+			t.w.WriteString(content[size:])
+		}
+		if strings.HasSuffix(line, "\n") {
+			t.lineBreak(1)
+		}
+	}
+}
+
+func (t *textWriter) writeNode(node *html.Node, pre bool) {
+	switch node.Type {
+	case html.TextNode:
+		if pre {
+			t.writePre(node.Data)
+		} else {
+			t.writeText(node.Data)
+		}
+		return
+	case html.ElementNode:
+		if skippedElements[node.Data] {
+			return
+		}
+		if node.Data == "br" {
+			t.lineBreak(1)
+			return
+		}
+		if node.Data == "pre" {
+			pre = true
+		}
+	case html.DocumentNode:
+	default:
+		return
+	}
+	breaks := blockElements[node.Data]
+	if node.Type == html.ElementNode && breaks > 0 {
+		t.lineBreak(breaks)
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		t.writeNode(child, pre)
+	}
+	if node.Type == html.ElementNode && breaks > 0 {
+		t.lineBreak(breaks)
+	}
+}
+
+// tocTitle returns the label of the first table of contents entry that points to the file
+func tocTitle(toc []eBookData.TOCEntry, href string) string {
+	for _, entry := range toc {
+		if entry.Href == href && entry.Label != "" {
+			return entry.Label
+		}
+		if title := tocTitle(entry.Children, href); title != "" {
+			return title
+		}
+	}
+	return ""
+}
+
+// WriteText writes the text of the content documents in reading order as UTF-8, every document starts with a chapter marker line.
+// Scripts and styles are dropped, paragraphs and headings are separated with an empty line.
+// The reader the epub was read from has to be still open. opts can be nil for the defaults.
+func (epub Epub) WriteText(w io.Writer, opts *TextOptions) error {
+	out := bufio.NewWriter(w)
+	index := 0
+	for _, item := range epub.spine {
+		if !item.Linear && !opts.includeNonLinear() {
+			continue
+		}
+		if item.MediaType != "application/xhtml+xml" && item.MediaType != "text/html" {
+			epub.log().Trace().Str("Href", item.Href).Str("MediaType", item.MediaType).Msg("Spine item is not a text")
+			continue
+		}
+		reader, err := epub.OpenItem(item)
+		if err != nil {
+			return err
+		}
+		doc, err := html.Parse(reader)
+		reader.Close()
+		if err != nil {
+			return createEpubFormatError(err)
+		}
+		if index > 0 {
+			out.WriteString("\n\n")
+		}
+		out.WriteString(opts.chapterMarker(index, item, tocTitle(epub.TableOfContents(), item.Href)))
+		out.WriteString("\n\n")
+		text := &textWriter{w: out}
+		text.writeNode(doc, false)
+		out.WriteString("\n")
+		index++
+		if err := out.Flush(); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
@@ -0,0 +1,198 @@
+package epub
+
+import (
+	"archive/zip"
+	"strings"
+	"sync"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/rs/zerolog"
+	"golang.org/x/net/html"
+)
+
+// lazyToc parses the table of contents on the first use, it is shared by the copies of the Epub
+type lazyToc struct {
+	once    sync.Once
+	load    func() []eBookData.TOCEntry
+	entries []eBookData.TOCEntry
+}
+
+func (l *lazyToc) get() []eBookData.TOCEntry {
+	if l == nil {
+		return nil
+	}
+	l.once.Do(func() {
+		if l.load != nil {
+			l.entries = l.load()
+			l.load = nil
+		}
+	})
+	return l.entries
+}
+
+// parseSpineToc returns the id of the NCX from the spine toc attribute
+func parseSpineToc(doc *xmlquery.Node, nsMap map[string]string) string {
+	expr, _ := xpath.CompileWithNS("/opf:package/opf:spine/@toc", nsMap)
+	for _, node := range xmlquery.QuerySelectorAll(doc, expr) {
+		return node.InnerText()
+	}
+	return ""
+}
+
+func tocEntry(base string, label string, href string) eBookData.TOCEntry {
+	return eBookData.TOCEntry{
+		Label:    strings.Join(strings.Fields(label), " "),
+		Href:     resolveHref(base, href),
+		Fragment: hrefFragment(href),
+	}
+}
+
+// xmlChildren returns the child elements with the local name, the namespace is not checked
+func xmlChildren(node *xmlquery.Node, name string) []*xmlquery.Node {
+	ret := make([]*xmlquery.Node, 0)
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == xmlquery.ElementNode && child.Data == name {
+			ret = append(ret, child)
+		}
+	}
+	return ret
+}
+
+func parseNavPoints(parent *xmlquery.Node, base string) []eBookData.TOCEntry {
+	ret := make([]eBookData.TOCEntry, 0)
+	for _, navPoint := range xmlChildren(parent, "navPoint") {
+		var label, src string
+		for _, navLabel := range xmlChildren(navPoint, "navLabel") {
+			for _, text := range xmlChildren(navLabel, "text") {
+				label = text.InnerText()
+			}
+		}
+		for _, content := range xmlChildren(navPoint, "content") {
+			src = content.SelectAttr("src")
+		}
+		entry := tocEntry(base, label, src)
+		entry.Children = parseNavPoints(navPoint, base)
+		ret = append(ret, entry)
+	}
+	return ret
+}
+
+// parseNcx reads the navMap of an EPUB2 NCX file
+func parseNcx(file *zip.File) ([]eBookData.TOCEntry, error) {
+	doc, err := readXml(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, ncx := range xmlChildren(doc, "ncx") {
+		for _, navMap := range xmlChildren(ncx, "navMap") {
+			return parseNavPoints(navMap, file.Name), nil
+		}
+	}
+	return nil, createCustomEpubFormatError("No navMap in " + file.Name)
+}
+
+func htmlChildren(node *html.Node, names ...string) []*html.Node {
+	ret := make([]*html.Node, 0)
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		for _, name := range names {
+			if child.Data == name {
+				ret = append(ret, child)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+func htmlText(node *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+	return b.String()
+}
+
+// parseNavList reads the <li> items of an <ol>, an item has an <a> or a <span> heading and an optional nested <ol>
+func parseNavList(ol *html.Node, base string) []eBookData.TOCEntry {
+	ret := make([]eBookData.TOCEntry, 0)
+	for _, li := range htmlChildren(ol, "li") {
+		var entry eBookData.TOCEntry
+		for _, heading := range htmlChildren(li, "a", "span") {
+			entry = tocEntry(base, htmlText(heading), htmlAttr(heading, "href"))
+			break
+		}
+		for _, nested := range htmlChildren(li, "ol") {
+			entry.Children = append(entry.Children, parseNavList(nested, base)...)
+		}
+		ret = append(ret, entry)
+	}
+	return ret
+}
+
+// parseNav reads the <nav epub:type="toc"> of an EPUB3 navigation document
+func parseNav(file *zip.File) ([]eBookData.TOCEntry, error) {
+	doc, err := readHtml(file)
+	if err != nil {
+		return nil, err
+	}
+	nav := findHtml(doc, func(node *html.Node) bool {
+		return node.Type == html.ElementNode && node.Data == "nav" && hasEpubType(node, "toc")
+	})
+	if nav == nil {
+		return nil, createCustomEpubFormatError("No toc nav in " + file.Name)
+	}
+	ol := findHtml(nav, func(node *html.Node) bool {
+		return node.Type == html.ElementNode && node.Data == "ol"
+	})
+	if ol == nil {
+		return make([]eBookData.TOCEntry, 0), nil
+	}
+	return parseNavList(ol, file.Name), nil
+}
+
+// parseToc reads the EPUB3 navigation document, or the EPUB2 NCX if there is no navigation document
+func parseToc(doc *xmlquery.Node, nsMap map[string]string, opfPath string, files map[string]*zip.File, logger *zerolog.Logger) ([]eBookData.TOCEntry, error) {
+	manifest := parseManifest(doc, nsMap, logger)
+	if item, ok := findManifestItem(manifest, func(m manifestItem) bool { return m.hasProperty("nav") }); ok {
+		if file, _ := lookupHref(files, opfPath, item.href); file != nil {
+			toc, err := parseNav(file)
+			if err == nil {
+				logger.Trace().Int("Entry qrt", len(toc)).Msg("Navigation document parsed")
+				return toc, nil
+			}
+			logger.Trace().Err(err).Msg("Invalid navigation document")
+		}
+	}
+	ncxId := parseSpineToc(doc, nsMap)
+	item, ok := findManifestItem(manifest, func(m manifestItem) bool { return m.id == ncxId && ncxId != "" })
+	if !ok {
+		item, ok = findManifestItem(manifest, func(m manifestItem) bool { return m.mediaType == "application/x-dtbncx+xml" })
+	}
+	if !ok {
+		logger.Trace().Msg("No table of contents")
+		return make([]eBookData.TOCEntry, 0), nil
+	}
+	file, name := lookupHref(files, opfPath, item.href)
+	if file == nil {
+		return nil, createCustomEpubFormatError("No NCX file " + name)
+	}
+	toc, err := parseNcx(file)
+	if err != nil {
+		return nil, err
+	}
+	logger.Trace().Int("Entry qrt", len(toc)).Msg("NCX parsed")
+	return toc, nil
+}
@@ -0,0 +1,370 @@
+package epub
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+type Severity string
+
+const (
+	// SEVERITY_ERROR is a violation of the specification, readers may fail on it
+	SEVERITY_ERROR Severity = "error"
+	// SEVERITY_WARNING is a problem that the readers usually tolerate
+	SEVERITY_WARNING Severity = "warning"
+)
+
+// FindingCode identifies the kind of the finding, it does not change between versions
+type FindingCode string
+
+const (
+	CODE_ZIP_INVALID           FindingCode = "zip-invalid"
+	CODE_MIMETYPE_MISSING      FindingCode = "mimetype-missing"
+	CODE_MIMETYPE_NOT_FIRST    FindingCode = "mimetype-not-first"
+	CODE_MIMETYPE_COMPRESSED   FindingCode = "mimetype-compressed"
+	CODE_MIMETYPE_EXTRA_FIELD  FindingCode = "mimetype-extra-field"
+	CODE_MIMETYPE_INVALID      FindingCode = "mimetype-invalid"
+	CODE_CONTAINER_MISSING     FindingCode = "container-missing"
+	CODE_CONTAINER_INVALID     FindingCode = "container-invalid"
+	CODE_ROOTFILE_MISSING      FindingCode = "rootfile-missing"
+	CODE_OPF_INVALID           FindingCode = "opf-invalid"
+	CODE_TITLE_MISSING         FindingCode = "title-missing"
+	CODE_IDENTIFIER_MISSING    FindingCode = "identifier-missing"
+	CODE_LANGUAGE_MISSING      FindingCode = "language-missing"
+	CODE_MODIFIED_MISSING      FindingCode = "modified-missing"
+	CODE_UNIQUE_ID_INVALID     FindingCode = "unique-identifier-invalid"
+	CODE_DUPLICATE_ID          FindingCode = "duplicate-id"
+	CODE_MANIFEST_ITEM_INVALID FindingCode = "manifest-item-invalid"
+	CODE_MANIFEST_FILE_MISSING FindingCode = "manifest-file-missing"
+	CODE_MANIFEST_HREF_CASE    FindingCode = "manifest-href-case"
+	CODE_MANIFEST_DUPLICATE    FindingCode = "manifest-duplicate-href"
+	CODE_SPINE_MISSING         FindingCode = "spine-missing"
+	CODE_SPINE_EMPTY           FindingCode = "spine-empty"
+	CODE_SPINE_IDREF_MISSING   FindingCode = "spine-idref-missing"
+	CODE_SPINE_TOC_MISSING     FindingCode = "spine-toc-missing"
+	CODE_FILE_NOT_IN_MANIFEST  FindingCode = "file-not-in-manifest"
+	CODE_COVER_META_MISSING    FindingCode = "cover-meta-missing"
+	CODE_COVER_INVALID         FindingCode = "cover-invalid"
+	CODE_TOC_LINK_MISSING      FindingCode = "toc-link-missing"
+)
+
+// Finding is a problem found by Validate
+type Finding struct {
+	Severity Severity
+	Code     FindingCode
+	// Location is the name of the file in the zip, that has the problem
+	Location string
+	Message  string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s %s %s: %s", f.Severity, f.Code, f.Location, f.Message)
+}
+
+// HasErrors reports whether there is a finding with error severity
+func HasErrors(findings []Finding) bool {
+	return slices.ContainsFunc(findings, func(f Finding) bool { return f.Severity == SEVERITY_ERROR })
+}
+
+type validator struct {
+	r        io.ReaderAt
+	zip      *zip.Reader
+	files    map[string]*zip.File
+	findings []Finding
+	logger   *zerolog.Logger
+}
+
+func (v *validator) add(severity Severity, code FindingCode, location string, format string, args ...any) {
+	finding := Finding{Severity: severity, Code: code, Location: location, Message: fmt.Sprintf(format, args...)}
+	v.logger.Trace().Str("Finding", finding.String()).Msg("Validation finding")
+	v.findings = append(v.findings, finding)
+}
+
+// validateMimetype checks that the mimetype is the first file, stored without compression and extra field
+func (v *validator) validateMimetype() {
+	file := v.files["mimetype"]
+	if file == nil {
+		v.add(SEVERITY_ERROR, CODE_MIMETYPE_MISSING, "mimetype", "No mimetype file")
+		return
+	}
+	first := v.zip.File[0] == file
+	if !first {
+		v.add(SEVERITY_ERROR, CODE_MIMETYPE_NOT_FIRST, "mimetype", "The mimetype is not the first file of the zip")
+	}
+	if file.Method != zip.Store {
+		v.add(SEVERITY_ERROR, CODE_MIMETYPE_COMPRESSED, "mimetype", "The mimetype is compressed")
+	}
+	// the local header at the start of the zip is the header of the mimetype only if it is the first file
+	header := make([]byte, 30)
+	if _, err := v.r.ReadAt(header, 0); first && err == nil && binary.LittleEndian.Uint32(header) == 0x04034b50 && binary.LittleEndian.Uint16(header[28:]) != 0 {
+		v.add(SEVERITY_WARNING, CODE_MIMETYPE_EXTRA_FIELD, "mimetype", "The mimetype has extra field")
+	}
+	reader, err := file.Open()
+	if err != nil {
+		v.add(SEVERITY_ERROR, CODE_MIMETYPE_INVALID, "mimetype", "The mimetype is not readable: %v", err)
+		return
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, 256))
+	if err != nil || string(data) != EPUB_MIMETYPE {
+		v.add(SEVERITY_ERROR, CODE_MIMETYPE_INVALID, "mimetype", "The mimetype is %q instead of %q", data, EPUB_MIMETYPE)
+	}
+}
+
+// validatePackage checks the package document, and returns the names of the files that are in the manifest
+func (v *validator) validatePackage(opfPath string) map[string]bool {
+	used := make(map[string]bool)
+	file := v.files[opfPath]
+	if file == nil {
+		v.add(SEVERITY_ERROR, CODE_ROOTFILE_MISSING, CONTAINER_PATH, "No package document %s", opfPath)
+		return used
+	}
+	doc, err := readXml(file)
+	if err != nil {
+		v.add(SEVERITY_ERROR, CODE_OPF_INVALID, opfPath, "The package document is not readable: %v", err)
+		return used
+	}
+	pkg := selectNode(doc, "/opf:package")
+	if pkg == nil {
+		v.add(SEVERITY_ERROR, CODE_OPF_INVALID, opfPath, "No package element in the OPF namespace")
+		return used
+	}
+	v.validateIds(doc, opfPath)
+	v.validateMetadata(doc, pkg, opfPath)
+	ids := v.validateManifest(doc, opfPath, used)
+	v.validateSpine(doc, opfPath, ids)
+	v.validateCover(doc, opfPath)
+	v.validateToc(doc, opfPath)
+	return used
+}
+
+func selectNode(doc *xmlquery.Node, query string) *xmlquery.Node {
+	expr, _ := xpath.CompileWithNS(query, opfNsMap)
+	return xmlquery.QuerySelector(doc, expr)
+}
+
+func selectNodes(doc *xmlquery.Node, query string) []*xmlquery.Node {
+	expr, _ := xpath.CompileWithNS(query, opfNsMap)
+	return xmlquery.QuerySelectorAll(doc, expr)
+}
+
+func (v *validator) validateIds(doc *xmlquery.Node, opfPath string) {
+	seen := make(map[string]bool)
+	for _, node := range xmlquery.Find(doc, "//*[@id]") {
+		id := node.SelectAttr("id")
+		if seen[id] {
+			v.add(SEVERITY_ERROR, CODE_DUPLICATE_ID, opfPath, "Duplicated id %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func (v *validator) validateMetadata(doc *xmlquery.Node, pkg *xmlquery.Node, opfPath string) {
+	required := []struct {
+		query string
+		code  FindingCode
+		name  string
+	}{
+		{"/opf:package/opf:metadata/dc:title", CODE_TITLE_MISSING, "dc:title"},
+		{"/opf:package/opf:metadata/dc:identifier", CODE_IDENTIFIER_MISSING, "dc:identifier"},
+		{"/opf:package/opf:metadata/dc:language", CODE_LANGUAGE_MISSING, "dc:language"},
+	}
+	for _, r := range required {
+		if !slices.ContainsFunc(selectNodes(doc, r.query), func(n *xmlquery.Node) bool { return strings.TrimSpace(n.InnerText()) != "" }) {
+			v.add(SEVERITY_ERROR, r.code, opfPath, "No %s in the metadata", r.name)
+		}
+	}
+	uniqueId := pkg.SelectAttr("unique-identifier")
+	identifiers := selectNodes(doc, "/opf:package/opf:metadata/dc:identifier")
+	if uniqueId == "" || !slices.ContainsFunc(identifiers, func(n *xmlquery.Node) bool { return n.SelectAttr("id") == uniqueId }) {
+		v.add(SEVERITY_ERROR, CODE_UNIQUE_ID_INVALID, opfPath, "The unique-identifier %q is not the id of a dc:identifier", uniqueId)
+	}
+	if strings.HasPrefix(strings.TrimSpace(pkg.SelectAttr("version")), "3") &&
+		selectNode(doc, "/opf:package/opf:metadata/opf:meta[@property='dcterms:modified' and not(@refines)]") == nil {
+		v.add(SEVERITY_ERROR, CODE_MODIFIED_MISSING, opfPath, "No dcterms:modified in the EPUB3 metadata")
+	}
+}
+
+// findFileIgnoreCase returns the file with the same name in other case
+func findFileIgnoreCase(files map[string]*zip.File, name string) string {
+	for other := range files {
+		if strings.EqualFold(other, name) {
+			return other
+		}
+	}
+	return ""
+}
+
+// validateManifest checks that the files of the manifest exist, and returns the ids of the items
+func (v *validator) validateManifest(doc *xmlquery.Node, opfPath string, used map[string]bool) map[string]bool {
+	ids := make(map[string]bool)
+	for _, node := range selectNodes(doc, "/opf:package/opf:manifest/opf:item") {
+		id, href, mediaType := node.SelectAttr("id"), node.SelectAttr("href"), node.SelectAttr("media-type")
+		if id == "" || href == "" || mediaType == "" {
+			v.add(SEVERITY_ERROR, CODE_MANIFEST_ITEM_INVALID, opfPath, "The manifest item %q has no id, href or media-type", id+" "+href)
+		}
+		if id != "" {
+			ids[id] = true
+		}
+		if href == "" || strings.Contains(href, "://") {
+			// remote resources are not in the zip
+			continue
+		}
+		file, name := lookupHref(v.files, opfPath, href)
+		switch {
+		case name == "":
+			v.add(SEVERITY_ERROR, CODE_MANIFEST_ITEM_INVALID, opfPath, "The href %q of the manifest item %q is outside of the container", href, id)
+		case file == nil && findFileIgnoreCase(v.files, name) != "":
+			v.add(SEVERITY_ERROR, CODE_MANIFEST_HREF_CASE, opfPath, "The href %q of the manifest item %q differs in case from %s", href, id, findFileIgnoreCase(v.files, name))
+			used[findFileIgnoreCase(v.files, name)] = true
+		case file == nil:
+			v.add(SEVERITY_ERROR, CODE_MANIFEST_FILE_MISSING, opfPath, "The file %s of the manifest item %q is missing", name, id)
+		case used[file.Name]:
+			v.add(SEVERITY_WARNING, CODE_MANIFEST_DUPLICATE, opfPath, "The file %s is in the manifest more than once", file.Name)
+		default:
+			used[file.Name] = true
+		}
+	}
+	return ids
+}
+
+func (v *validator) validateSpine(doc *xmlquery.Node, opfPath string, ids map[string]bool) {
+	spine := selectNode(doc, "/opf:package/opf:spine")
+	if spine == nil {
+		v.add(SEVERITY_ERROR, CODE_SPINE_MISSING, opfPath, "No spine")
+		return
+	}
+	if toc := spine.SelectAttr("toc"); toc != "" && !ids[toc] {
+		v.add(SEVERITY_ERROR, CODE_SPINE_TOC_MISSING, opfPath, "The spine toc %q is not a manifest item", toc)
+	}
+	itemrefs := selectNodes(doc, "/opf:package/opf:spine/opf:itemref")
+	if len(itemrefs) == 0 {
+		v.add(SEVERITY_ERROR, CODE_SPINE_EMPTY, opfPath, "The spine has no itemref")
+	}
+	for _, itemref := range itemrefs {
+		if idref := itemref.SelectAttr("idref"); !ids[idref] {
+			v.add(SEVERITY_ERROR, CODE_SPINE_IDREF_MISSING, opfPath, "The spine itemref %q is not a manifest item", idref)
+		}
+	}
+}
+
+// validateCover checks that the cover definitions are readable, and the cover image, found by the other cover definitions,
+// has the EPUB2 <meta name="cover">
+func (v *validator) validateCover(doc *xmlquery.Node, opfPath string) {
+	cover, err := findCover(doc, opfNsMap, opfPath, v.files, v.logger)
+	if err != nil {
+		v.add(SEVERITY_WARNING, CODE_COVER_INVALID, opfPath, "A cover definition is not readable: %v", err)
+	}
+	if cover.href == "" || cover.source == eBookData.COVER_SOURCE_META || !strings.HasPrefix(cover.mediaType, "image/") {
+		return
+	}
+	v.add(SEVERITY_WARNING, CODE_COVER_META_MISSING, opfPath, "The cover %s found by %s has no cover meta", cover.href, cover.source)
+}
+
+// validateToc checks that the entries of the NCX and the navigation document link to files of the zip.
+// The files that can't be parsed are not checked.
+func (v *validator) validateToc(doc *xmlquery.Node, opfPath string) {
+	for _, item := range parseManifest(doc, opfNsMap, v.logger) {
+		parse := parseNcx
+		if item.hasProperty("nav") {
+			parse = parseNav
+		} else if item.mediaType != "application/x-dtbncx+xml" {
+			continue
+		}
+		file, _ := lookupHref(v.files, opfPath, item.href)
+		if file == nil {
+			continue
+		}
+		toc, err := parse(file)
+		if err != nil {
+			v.logger.Trace().Err(err).Str("File", file.Name).Msg("Table of contents not readable, not checked")
+			continue
+		}
+		v.validateTocEntries(toc, file.Name)
+	}
+}
+
+func (v *validator) validateTocEntries(entries []eBookData.TOCEntry, location string) {
+	for _, entry := range entries {
+		if entry.Href != "" && v.files[entry.Href] == nil {
+			v.add(SEVERITY_ERROR, CODE_TOC_LINK_MISSING, location, "The entry %q links to the missing file %s", entry.Label, entry.Href)
+		}
+		v.validateTocEntries(entry.Children, location)
+	}
+}
+
+// Validate checks the structure of an epub: the OCF rules of the mimetype and the container, the required metadata,
+// the manifest, the spine, the cover definitions, the links of the table of contents, the duplicate ids and the files that are not in the manifest.
+// The findings are in the order of the checks, an empty result means no problem was found.
+func Validate(r io.ReaderAt, size int64) []Finding {
+	v := &validator{r: r, files: make(map[string]*zip.File), logger: &log.Logger}
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		v.add(SEVERITY_ERROR, CODE_ZIP_INVALID, "", "Not a zip file: %v", err)
+		return v.findings
+	}
+	v.zip = zipReader
+	for _, file := range zipReader.File {
+		v.files[file.Name] = file
+	}
+	if len(zipReader.File) == 0 {
+		v.add(SEVERITY_ERROR, CODE_ZIP_INVALID, "", "Empty zip file")
+		return v.findings
+	}
+	v.validateMimetype()
+
+	container := v.files[CONTAINER_PATH]
+	if container == nil {
+		v.add(SEVERITY_ERROR, CODE_CONTAINER_MISSING, CONTAINER_PATH, "No META-INF/container.xml")
+		return v.findings
+	}
+	renditions, err := parseContainer(container, v.logger)
+	if err != nil || len(renditions) == 0 {
+		v.add(SEVERITY_ERROR, CODE_CONTAINER_INVALID, CONTAINER_PATH, "No package rootfile in the container")
+		return v.findings
+	}
+	used := map[string]bool{"mimetype": true}
+	for _, rendition := range renditions {
+		used[rendition.FullPath] = true
+		for name := range v.validatePackage(rendition.FullPath) {
+			used[name] = true
+		}
+	}
+	names := make([]string, 0)
+	for name := range v.files {
+		if !used[name] && !strings.HasPrefix(name, "META-INF/") && !strings.HasSuffix(name, "/") {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		v.add(SEVERITY_WARNING, CODE_FILE_NOT_IN_MANIFEST, name, "The file is not in the manifest")
+	}
+	return v.findings
+}
+
+// ValidateFile checks the epub file at path, see Validate
+func ValidateFile(name string) ([]Finding, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, createEpubFormatError(err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, createEpubFormatError(err)
+	}
+	return Validate(f, stat.Size()), nil
+}
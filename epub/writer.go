@@ -0,0 +1,479 @@
+package epub
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
+)
+
+// EPUB_MIMETYPE is the content of the mimetype file, the first entry of every epub
+const EPUB_MIMETYPE = "application/epub+zip"
+
+// the folder of the package document and the content in the written epub
+const CONTENT_DIR = "OEBPS"
+
+const (
+	opfName = "content.opf"
+	navName = "nav.xhtml"
+	ncxName = "toc.ncx"
+)
+
+// Chapter is a content document of the written epub, in reading order
+type Chapter struct {
+	// Href is the path of the file relative to the content folder, eg. "text/chapter1.xhtml"
+	Href string
+	// Title is the label in the table of contents, chapters without title are left out of it
+	Title string
+	// Content is the whole XHTML document
+	Content []byte
+	// NonLinear marks the auxiliary content, eg. footnotes (linear="no")
+	NonLinear bool
+	// Properties of the manifest item, eg. "scripted" or "svg"
+	Properties []string
+}
+
+// Resource is an other file of the written epub, eg. an image, a stylesheet or a font
+type Resource struct {
+	// Href is the path of the file relative to the content folder, eg. "images/map.png"
+	Href string
+	// MediaType is detected from the extension if it is empty
+	MediaType string
+	Data      []byte
+	// Properties of the manifest item
+	Properties []string
+}
+
+// EpubContent is everything WriteEpub puts into the epub
+type EpubContent struct {
+	Metadata  eBookData.Metadata
+	Chapters  []Chapter
+	Resources []Resource
+	// Cover is the optional cover image, it gets the cover-image property and the EPUB2 cover meta
+	Cover *Resource
+}
+
+// writeMimetype writes the mimetype file, that has to be the first one, stored without compression and extra field
+func writeMimetype(zw *zip.Writer) error {
+	data := []byte(EPUB_MIMETYPE)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "mimetype",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   uint64(len(data)),
+		UncompressedSize64: uint64(len(data)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func containerXml(opfPath string) []byte {
+	return []byte(xml.Header + `<container version="1.0" xmlns="` + CONTAINER_NS + `">
+  <rootfiles>
+    <rootfile full-path="` + xmlEscape(opfPath) + `" media-type="` + PACKAGE_MEDIA_TYPE + `"/>
+  </rootfiles>
+</container>
+`)
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// hrefEscape escapes the path for the href attributes, eg. the spaces
+func hrefEscape(href string) string {
+	return (&url.URL{Path: href}).EscapedPath()
+}
+
+func newUuid() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// coreMediaTypes are the media types of the EPUB core media types and the other files of an epub by extension.
+// mime.TypeByExtension depends on the mime tables of the system and misses most of them, it is only the fallback.
+var coreMediaTypes = map[string]string{
+	".xhtml": "application/xhtml+xml",
+	".css":   "text/css",
+	".js":    "text/javascript",
+	".gif":   "image/gif",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".png":   "image/png",
+	".svg":   "image/svg+xml",
+	".webp":  "image/webp",
+	".mp3":   "audio/mpeg",
+	".m4a":   "audio/mp4",
+	".ogg":   "audio/ogg",
+	".opus":  "audio/ogg",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ncx":   "application/x-dtbncx+xml",
+	".smil":  "application/smil+xml",
+	".pls":   "application/pls+xml",
+}
+
+// mediaTypeByExtension returns the media type of the href by its extension, empty if it is unknown
+func mediaTypeByExtension(href string) string {
+	ext := strings.ToLower(path.Ext(href))
+	if mediaType, ok := coreMediaTypes[ext]; ok {
+		return mediaType
+	}
+	mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+	return mediaType
+}
+
+// writtenItem is a manifest item of the written epub
+type writtenItem struct {
+	id         string
+	href       string
+	mediaType  string
+	properties []string
+	data       []byte
+}
+
+// collectItems validates the hrefs and returns the manifest items of the chapters and the resources
+func collectItems(content EpubContent) ([]writtenItem, error) {
+	used := map[string]bool{opfName: true, navName: true, ncxName: true}
+	ret := make([]writtenItem, 0, len(content.Chapters)+len(content.Resources)+1)
+	add := func(id string, href string, mediaType string, properties []string, data []byte) error {
+		if !fs.ValidPath(href) || href == "." {
+			return createCustomEpubFormatError("Invalid href " + href)
+		}
+		if used[href] {
+			return createCustomEpubFormatError("Duplicated href " + href)
+		}
+		if mediaType == "" {
+			mediaType = mediaTypeByExtension(href)
+		}
+		if mediaType == "" {
+			return createCustomEpubFormatError("No media type of " + href)
+		}
+		used[href] = true
+		ret = append(ret, writtenItem{id: id, href: href, mediaType: mediaType, properties: properties, data: data})
+		return nil
+	}
+	for i, chapter := range content.Chapters {
+		if err := add("chapter-"+strconv.Itoa(i+1), chapter.Href, "application/xhtml+xml", chapter.Properties, chapter.Content); err != nil {
+			return nil, err
+		}
+	}
+	if content.Cover != nil {
+		properties := append([]string{"cover-image"}, content.Cover.Properties...)
+		if err := add("cover-image", content.Cover.Href, content.Cover.MediaType, properties, content.Cover.Data); err != nil {
+			return nil, err
+		}
+	}
+	for i, resource := range content.Resources {
+		if err := add("resource-"+strconv.Itoa(i+1), resource.Href, resource.MediaType, resource.Properties, resource.Data); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// identifierValue returns the dc:identifier text, ISBN and UUID get the urn prefix
+func identifierValue(scheme string, value string) string {
+	switch scheme {
+	case eBookData.SCHEME_ISBN:
+		return "urn:isbn:" + value
+	case eBookData.SCHEME_UUID:
+		return "urn:uuid:" + value
+	}
+	return value
+}
+
+// opfMetadata writes the metadata, refinements and the EPUB2 compatible metas of the package document
+type opfMetadata struct {
+	b       strings.Builder
+	refines strings.Builder
+}
+
+func (o *opfMetadata) element(name string, id string, value string) {
+	if value == "" {
+		return
+	}
+	if id != "" {
+		fmt.Fprintf(&o.b, "    <%s id=\"%s\">%s</%s>\n", name, id, xmlEscape(value), name)
+		return
+	}
+	fmt.Fprintf(&o.b, "    <%s>%s</%s>\n", name, xmlEscape(value), name)
+}
+
+func (o *opfMetadata) refine(id string, property string, scheme string, value string) {
+	if value == "" {
+		return
+	}
+	if scheme != "" {
+		fmt.Fprintf(&o.refines, "    <meta refines=\"#%s\" property=\"%s\" scheme=\"%s\">%s</meta>\n", id, property, scheme, xmlEscape(value))
+		return
+	}
+	fmt.Fprintf(&o.refines, "    <meta refines=\"#%s\" property=\"%s\">%s</meta>\n", id, property, xmlEscape(value))
+}
+
+func (o *opfMetadata) persons(name string, prefix string, persons []eBookData.Person) {
+	for i, person := range persons {
+		id := prefix + strconv.Itoa(i+1)
+		o.element(name, id, person.Name)
+		o.refine(id, "file-as", "", person.FileAs)
+		for _, role := range person.Roles {
+			o.refine(id, "role", "marc:relators", role)
+		}
+	}
+}
+
+// identifiers writes the dc:identifier elements and returns the id and the value of the unique identifier.
+// The unique identifier is the first UUID, or the first ISBN, or the first identifier.
+func (o *opfMetadata) identifiers(metadata eBookData.Metadata) (string, string) {
+	identifiers := make([]eBookData.Identifier, 0)
+	for _, identifier := range metadata.Identifiers() {
+		identifiers = eBookData.AppendIdentifier(identifiers, eBookData.NormalizeScheme(identifier.Scheme), identifier.Value)
+	}
+	if eBookData.FindIdentifier(identifiers, eBookData.SCHEME_ISBN) == "" {
+		identifiers = eBookData.AppendIdentifier(identifiers, eBookData.SCHEME_ISBN, metadata.ISBN())
+	}
+	if len(identifiers) == 0 {
+		identifiers = append(identifiers, eBookData.Identifier{Scheme: eBookData.SCHEME_UUID, Value: newUuid()})
+	}
+	unique := 0
+	for _, scheme := range []string{eBookData.SCHEME_UUID, eBookData.SCHEME_ISBN} {
+		if idx := slices.IndexFunc(identifiers, func(i eBookData.Identifier) bool { return i.Scheme == scheme }); idx >= 0 {
+			unique = idx
+			break
+		}
+	}
+	var uniqueId, uniqueValue string
+	for i, identifier := range identifiers {
+		id := "id-" + strconv.Itoa(i+1)
+		value := identifierValue(identifier.Scheme, identifier.Value)
+		if i == unique {
+			uniqueId, uniqueValue = id, value
+		}
+		o.element("dc:identifier", id, value)
+		if identifier.Scheme != "" && identifier.Scheme != eBookData.SCHEME_ISBN && identifier.Scheme != eBookData.SCHEME_UUID {
+			o.refine(id, "identifier-type", "", identifier.Scheme)
+		}
+	}
+	return uniqueId, uniqueValue
+}
+
+func (o *opfMetadata) collections(metadata eBookData.Metadata) {
+	collections := slices.Clone(metadata.Collections())
+	if metadata.Series() != "" && !slices.ContainsFunc(collections, func(c eBookData.Collection) bool { return c.Name == metadata.Series() }) {
+		collections = append(collections, eBookData.Collection{Name: metadata.Series(), Type: "series", Position: metadata.SeriesIndex()})
+	}
+	for i, collection := range collections {
+		id := "collection-" + strconv.Itoa(i+1)
+		fmt.Fprintf(&o.b, "    <meta property=\"belongs-to-collection\" id=\"%s\">%s</meta>\n", id, xmlEscape(collection.Name))
+		o.refine(id, "collection-type", "", collection.Type)
+		if collection.Position != 0 {
+			o.refine(id, "group-position", "", strconv.FormatFloat(collection.Position, 'f', -1, 64))
+		}
+	}
+}
+
+// buildOpf returns the EPUB3 package document and the unique identifier of the book
+func buildOpf(content EpubContent, items []writtenItem) ([]byte, string) {
+	metadata := content.Metadata
+	o := &opfMetadata{}
+	uniqueId, uid := o.identifiers(metadata)
+
+	o.element("dc:title", "title", metadata.Title())
+	o.refine("title", "title-type", "", "main")
+	o.refine("title", "file-as", "", metadata.SortTitle())
+	if metadata.Subtitle() != "" {
+		o.element("dc:title", "subtitle", metadata.Subtitle())
+		o.refine("subtitle", "title-type", "", "subtitle")
+	}
+	authors := metadata.Authors()
+	if len(authors) == 0 {
+		for _, name := range metadata.Author() {
+			authors = append(authors, eBookData.Person{Name: name, Roles: []string{eBookData.ROLE_AUTHOR}})
+		}
+	}
+	o.persons("dc:creator", "creator-", authors)
+	o.persons("dc:contributor", "contributor-", metadata.Contributors())
+	language := metadata.Language()
+	if language == "" {
+		language = "und"
+	}
+	o.element("dc:language", "", language)
+	o.element("dc:publisher", "", metadata.Publisher())
+	o.element("dc:date", "", metadata.PublishedDate().String())
+	o.element("dc:description", "", metadata.Description())
+	for _, subject := range metadata.Subjects() {
+		o.element("dc:subject", "", subject)
+	}
+	o.element("dc:rights", "", metadata.Rights())
+	o.collections(metadata)
+
+	modified := time.Now()
+	if !metadata.Modified().IsZero() {
+		modified = metadata.Modified().Time
+	}
+	fmt.Fprintf(&o.b, "    <meta property=\"dcterms:modified\">%s</meta>\n", modified.UTC().Format("2006-01-02T15:04:05Z"))
+	if metadata.Series() != "" {
+		fmt.Fprintf(&o.b, "    <meta name=\"calibre:series\" content=\"%s\"/>\n", xmlEscape(metadata.Series()))
+		fmt.Fprintf(&o.b, "    <meta name=\"calibre:series_index\" content=\"%s\"/>\n", strconv.FormatFloat(metadata.SeriesIndex(), 'f', -1, 64))
+	}
+	if metadata.SortTitle() != "" {
+		fmt.Fprintf(&o.b, "    <meta name=\"calibre:title_sort\" content=\"%s\"/>\n", xmlEscape(metadata.SortTitle()))
+	}
+	if metadata.Rating() > 0 {
+		// calibre stores the rating on a 0-10 scale
+		fmt.Fprintf(&o.b, "    <meta name=\"calibre:rating\" content=\"%s\"/>\n", strconv.FormatFloat(metadata.Rating()*2, 'f', -1, 64))
+	}
+	if !metadata.Added().IsZero() {
+		fmt.Fprintf(&o.b, "    <meta name=\"calibre:timestamp\" content=\"%s\"/>\n", metadata.Added().String())
+	}
+	if content.Cover != nil {
+		o.b.WriteString("    <meta name=\"cover\" content=\"cover-image\"/>\n")
+	}
+
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, "<package xmlns=\"http://www.idpf.org/2007/opf\" version=\"3.0\" unique-identifier=\"%s\" xml:lang=\"%s\">\n", uniqueId, xmlEscape(language))
+	b.WriteString("  <metadata xmlns:dc=\"http://purl.org/dc/elements/1.1/\">\n")
+	b.WriteString(o.b.String())
+	b.WriteString(o.refines.String())
+	b.WriteString("  </metadata>\n  <manifest>\n")
+	fmt.Fprintf(&b, "    <item id=\"nav\" href=\"%s\" media-type=\"application/xhtml+xml\" properties=\"nav\"/>\n", navName)
+	fmt.Fprintf(&b, "    <item id=\"ncx\" href=\"%s\" media-type=\"application/x-dtbncx+xml\"/>\n", ncxName)
+	for _, item := range items {
+		fmt.Fprintf(&b, "    <item id=\"%s\" href=\"%s\" media-type=\"%s\"", item.id, xmlEscape(hrefEscape(item.href)), xmlEscape(item.mediaType))
+		if len(item.properties) > 0 {
+			fmt.Fprintf(&b, " properties=\"%s\"", xmlEscape(strings.Join(item.properties, " ")))
+		}
+		b.WriteString("/>\n")
+	}
+	b.WriteString("  </manifest>\n  <spine toc=\"ncx\">\n")
+	for i, chapter := range content.Chapters {
+		fmt.Fprintf(&b, "    <itemref idref=\"%s\"", items[i].id)
+		if chapter.NonLinear {
+			b.WriteString(" linear=\"no\"")
+		}
+		b.WriteString("/>\n")
+	}
+	b.WriteString("  </spine>\n</package>\n")
+	return []byte(b.String()), uid
+}
+
+// tocChapters returns the chapters with title, or the first chapter with the title of the book if none has,
+// as the table of contents can not be empty
+func tocChapters(content EpubContent) []Chapter {
+	ret := slices.DeleteFunc(slices.Clone(content.Chapters), func(c Chapter) bool { return c.Title == "" })
+	if len(ret) == 0 {
+		first := content.Chapters[0]
+		first.Title = content.Metadata.Title()
+		ret = append(ret, first)
+	}
+	return ret
+}
+
+// buildNav returns the EPUB3 navigation document with the chapters that have title
+func buildNav(content EpubContent) []byte {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString("<!DOCTYPE html>\n<html xmlns=\"http://www.w3.org/1999/xhtml\" xmlns:epub=\"http://www.idpf.org/2007/ops\">\n")
+	fmt.Fprintf(&b, "<head><title>%s</title></head>\n<body>\n<nav epub:type=\"toc\" id=\"toc\">\n<ol>\n", xmlEscape(content.Metadata.Title()))
+	for _, chapter := range tocChapters(content) {
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", xmlEscape(hrefEscape(chapter.Href)), xmlEscape(chapter.Title))
+	}
+	b.WriteString("</ol>\n</nav>\n</body>\n</html>\n")
+	return []byte(b.String())
+}
+
+// buildNcx returns the EPUB2 NCX with the same entries as the navigation document
+func buildNcx(content EpubContent, uid string) []byte {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString("<ncx xmlns=\"http://www.daisy.org/z3986/2005/ncx/\" version=\"2005-1\">\n")
+	fmt.Fprintf(&b, "<head><meta name=\"dtb:uid\" content=\"%s\"/><meta name=\"dtb:depth\" content=\"1\"/></head>\n", xmlEscape(uid))
+	fmt.Fprintf(&b, "<docTitle><text>%s</text></docTitle>\n<navMap>\n", xmlEscape(content.Metadata.Title()))
+	for i, chapter := range tocChapters(content) {
+		order := i + 1
+		fmt.Fprintf(&b, "<navPoint id=\"navpoint-%d\" playOrder=\"%d\"><navLabel><text>%s</text></navLabel><content src=\"%s\"/></navPoint>\n",
+			order, order, xmlEscape(chapter.Title), xmlEscape(hrefEscape(chapter.Href)))
+	}
+	b.WriteString("</navMap>\n</ncx>\n")
+	return []byte(b.String())
+}
+
+// WriteEpub writes an EPUB3 book with EPUB2 NCX fallback: the stored mimetype first, META-INF/container.xml,
+// the package document, the navigation document, the NCX and the files of the content under CONTENT_DIR.
+// A UUID identifier is generated if the metadata has no identifier, the modification date is the current time if it is not set.
+func WriteEpub(w io.Writer, content EpubContent) error {
+	if content.Metadata == nil {
+		return createCustomEpubFormatError("No metadata")
+	}
+	if content.Metadata.Title() == "" {
+		return createCustomEpubFormatError("No title in the metadata")
+	}
+	if len(content.Chapters) == 0 {
+		return createCustomEpubFormatError("No chapter")
+	}
+	items, err := collectItems(content)
+	if err != nil {
+		return err
+	}
+	opf, uid := buildOpf(content, items)
+
+	zw := zip.NewWriter(w)
+	if err := writeMimetype(zw); err != nil {
+		return createEpubWriteError(err)
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{CONTAINER_PATH, containerXml(CONTENT_DIR + "/" + opfName)},
+		{CONTENT_DIR + "/" + opfName, opf},
+		{CONTENT_DIR + "/" + navName, buildNav(content)},
+		{CONTENT_DIR + "/" + ncxName, buildNcx(content, uid)},
+	}
+	for _, file := range files {
+		if err := writeZipFile(zw, file.name, file.data); err != nil {
+			return createEpubWriteError(err)
+		}
+	}
+	for _, item := range items {
+		if err := writeZipFile(zw, CONTENT_DIR+"/"+item.href, item.data); err != nil {
+			return createEpubWriteError(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return createEpubWriteError(err)
+	}
+	return nil
+}
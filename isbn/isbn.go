@@ -0,0 +1,157 @@
+package isbn
+
+import (
+	"fmt"
+	"strings"
+)
+
+type IsbnError struct {
+	msg   string
+	value string
+}
+
+func (e *IsbnError) Error() string {
+	return fmt.Sprintf("%s : %s", e.msg, e.value)
+}
+
+func createIsbnError(msg string, value string) *IsbnError {
+	return &IsbnError{msg: msg, value: value}
+}
+
+var prefixes = []string{"urn:isbn:", "isbn-13:", "isbn-10:", "isbn13:", "isbn10:", "isbn:", "isbn-13", "isbn-10", "isbn"}
+
+// Clean removes the "ISBN:" like prefixes, the hyphens and the spaces, and upper cases the X check digit.
+// The result is not validated.
+func Clean(value string) string {
+	value = strings.TrimSpace(value)
+	lower := strings.ToLower(value)
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(lower, prefix) {
+			value = value[len(prefix):]
+			break
+		}
+	}
+	var b strings.Builder
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == 'x' || r == 'X':
+			b.WriteRune('X')
+		case r == '-' || r == ' ' || r == '\t' || r == '\u00a0' || r == '\u2010' || r == '\u2011' || r == '\u2013':
+			// separators
+		default:
+			// anything else makes the value invalid, keep it for the validation
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func checkDigit10(digits string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(digits[i]-'0') * (10 - i)
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return byte('0' + check)
+}
+
+func checkDigit13(digits string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(digits[i]-'0') * weight
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func allDigits(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// IsValid10 checks the length and the check digit of a cleaned ISBN-10
+func IsValid10(value string) bool {
+	if len(value) != 10 || !allDigits(value[:9]) {
+		return false
+	}
+	return value[9] == checkDigit10(value)
+}
+
+// IsValid13 checks the length, the 978/979 prefix and the check digit of a cleaned ISBN-13
+func IsValid13(value string) bool {
+	if len(value) != 13 || !allDigits(value) {
+		return false
+	}
+	if !strings.HasPrefix(value, "978") && !strings.HasPrefix(value, "979") {
+		return false
+	}
+	return value[12] == checkDigit13(value)
+}
+
+// Validate cleans the value and returns an error if it is not a valid ISBN-10 or ISBN-13
+func Validate(value string) error {
+	cleaned := Clean(value)
+	switch len(cleaned) {
+	case 10:
+		if !IsValid10(cleaned) {
+			return createIsbnError("Invalid ISBN-10", value)
+		}
+	case 13:
+		if !IsValid13(cleaned) {
+			return createIsbnError("Invalid ISBN-13", value)
+		}
+	default:
+		return createIsbnError("Invalid ISBN length", value)
+	}
+	return nil
+}
+
+func IsValid(value string) bool {
+	return Validate(value) == nil
+}
+
+// To13 converts a valid ISBN-10 or ISBN-13 to a cleaned ISBN-13
+func To13(value string) (string, error) {
+	if err := Validate(value); err != nil {
+		return "", err
+	}
+	cleaned := Clean(value)
+	if len(cleaned) == 13 {
+		return cleaned, nil
+	}
+	digits := "978" + cleaned[:9]
+	return digits + string(checkDigit13(digits)), nil
+}
+
+// To10 converts a valid ISBN-10 or 978 prefixed ISBN-13 to a cleaned ISBN-10. 979 prefixed ISBNs have no ISBN-10 form.
+func To10(value string) (string, error) {
+	if err := Validate(value); err != nil {
+		return "", err
+	}
+	cleaned := Clean(value)
+	if len(cleaned) == 10 {
+		return cleaned, nil
+	}
+	if !strings.HasPrefix(cleaned, "978") {
+		return "", createIsbnError("No ISBN-10 form", value)
+	}
+	digits := cleaned[3:12]
+	return digits + string(checkDigit10(digits)), nil
+}
+
+// Normalize returns the ISBN-13 form of a valid ISBN, this is the form used by the metadata
+func Normalize(value string) (string, error) {
+	return To13(value)
+}
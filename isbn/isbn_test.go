@@ -0,0 +1,47 @@
+package isbn
+
+import "testing"
+
+func TestClean(t *testing.T) {
+	tests := map[string]string{
+		"ISBN: 978-0-306-40615-7": "9780306406157",
+		"isbn 0-8044-2957-x":      "080442957X",
+		"urn:isbn:9780306406157":  "9780306406157",
+		"ISBN-13 978 0306406157":  "9780306406157",
+	}
+	for value, expected := range tests {
+		if got := Clean(value); got != expected {
+			t.Errorf("Clean(%q) = %q, expected %q", value, got, expected)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := []string{"9780306406157", "0-306-40615-2", "080442957X", "979-10-90636-07-1"}
+	for _, value := range valid {
+		if err := Validate(value); err != nil {
+			t.Errorf("Validate(%q) failed: %v", value, err)
+		}
+	}
+	invalid := []string{"9780306406158", "0306406153", "12345", "97803064061AB", "9770306406155", ""}
+	for _, value := range invalid {
+		if Validate(value) == nil {
+			t.Errorf("Validate(%q) should fail", value)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	if got, err := To13("0-306-40615-2"); err != nil || got != "9780306406157" {
+		t.Errorf("To13 = %q (err: %v)", got, err)
+	}
+	if got, err := To10("978-0-8044-2957-3"); err != nil || got != "080442957X" {
+		t.Errorf("To10 = %q (err: %v)", got, err)
+	}
+	if _, err := To10("9791090636071"); err == nil {
+		t.Errorf("To10 of a 979 ISBN should fail")
+	}
+	if got, err := Normalize("ISBN 0306406152"); err != nil || got != "9780306406157" {
+		t.Errorf("Normalize = %q (err: %v)", got, err)
+	}
+}
@@ -1,19 +1,23 @@
 package mobipocket
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 
 	"github.com/ignisVeneficus/ebook/eBookData"
 	"github.com/ignisVeneficus/ebook/mobipocket/palmdb"
 
-	"github.com/rs/zerolog/log"
 	"golang.org/x/text/encoding/charmap"
 )
 
 const ENCODING_CP1252 = "CP1252"
 const ENCODING_UTF8 = "utf8"
 
+// NO_RECORD is the value of the unset record indexes, eg. the EXTH cover and thumbnail offsets
+const NO_RECORD = 0xFFFFFFFF
+
 func readLongInteger(data []byte, start int) (int, int) {
 	return int(binary.BigEndian.Uint32(data[start : start+4])), start + 4
 }
@@ -42,9 +46,33 @@ func readStringFull(data []byte, encoding string) (string, error) {
 	}
 }
 
+type MobiError struct {
+	msg  string
+	root error
+}
+
+func (m *MobiError) Error() string {
+	if m.root == nil {
+		return m.msg
+	}
+	return fmt.Sprintf("%s : %s", m.msg, m.root.Error())
+}
+
+func (m *MobiError) Unwrap() error {
+	return m.root
+}
+
+func createMobiFormatError(root error) *MobiError {
+	return &MobiError{msg: "Mobipocket format error", root: root}
+}
+func createCustomMobiFormatError(msg string) *MobiError {
+	lastError := MobiError{msg: msg}
+	return createMobiFormatError(&lastError)
+}
+
 type Mobipocket struct {
 	db           palmdb.Db
-	cover        []byte
+	cover        *eBookData.Cover
 	compression  int
 	bookType     int
 	textEncoding string
@@ -68,42 +96,122 @@ type exthRecord struct {
 
 type mobiMetadata struct {
 	title          string
-	author         []string
-	contributor    []string
+	authors        []eBookData.Person
+	contributors   []eBookData.Person
 	isbn           string
 	publisher      string
-	publishingDate string
+	publishingDate eBookData.Date
+	language       string
+	description    string
+	subjects       []string
+	rights         string
+	identifiers    []eBookData.Identifier
+	modified       eBookData.Date
 }
 
 func (m mobiMetadata) Author() []string {
-	return m.author
+	return eBookData.Names(m.authors, eBookData.AUTHOR_NAME_DISPLAY)
+}
+func (m mobiMetadata) Authors() []eBookData.Person {
+	return m.authors
 }
 func (m mobiMetadata) Title() string {
 	return m.title
 }
+
+// Subtitle is not stored in mobipocket files
+func (m mobiMetadata) Subtitle() string {
+	return ""
+}
 func (m mobiMetadata) Publisher() string {
 	return m.publisher
 }
 func (m mobiMetadata) PubDate() string {
+	return m.publishingDate.YearString()
+}
+func (m mobiMetadata) PublishedDate() eBookData.Date {
 	return m.publishingDate
 }
 func (m mobiMetadata) ISBN() string {
 	return m.isbn
 }
 func (m mobiMetadata) Contributor() []string {
-	return m.contributor
+	return eBookData.Names(m.contributors, eBookData.AUTHOR_NAME_DISPLAY)
+}
+func (m mobiMetadata) Contributors() []eBookData.Person {
+	return m.contributors
+}
+func (m mobiMetadata) Language() string {
+	return m.language
+}
+func (m mobiMetadata) Description() string {
+	return m.description
+}
+func (m mobiMetadata) Subjects() []string {
+	return m.subjects
+}
+
+// Series is not stored in mobipocket files
+func (m mobiMetadata) Series() string {
+	return ""
+}
+func (m mobiMetadata) SeriesIndex() float64 {
+	return 0
+}
+func (m mobiMetadata) Collections() []eBookData.Collection {
+	return nil
+}
+func (m mobiMetadata) Rights() string {
+	return m.rights
+}
+func (m mobiMetadata) Identifiers() []eBookData.Identifier {
+	return m.identifiers
+}
+func (m mobiMetadata) Modified() eBookData.Date {
+	return m.modified
 }
 
-func ReadMobi(f io.Reader) (*Mobipocket, error) {
-	log.Logger.Debug().Msg("Start read mobipocket file")
-	defer log.Logger.Debug().Msg("End read mobipocket file")
+// The library metadata is not stored in mobipocket files
+func (m mobiMetadata) SortTitle() string {
+	return ""
+}
+func (m mobiMetadata) Rating() float64 {
+	return 0
+}
+func (m mobiMetadata) Added() eBookData.Date {
+	return eBookData.Date{}
+}
+func (m mobiMetadata) Custom() map[string]any {
+	return nil
+}
+func (m mobiMetadata) UserCategories() map[string][]string {
+	return nil
+}
+
+// ReadMobi reads the metadata and the cover of a mobipocket file. opts can be nil for the defaults.
+// Mobipocket files have no sort names, so the AuthorName option has no effect.
+func ReadMobi(f io.Reader, opts *eBookData.ParseOptions) (*Mobipocket, error) {
+	logger := opts.Log()
+	logger.Debug().Msg("Start read mobipocket file")
+	defer logger.Debug().Msg("End read mobipocket file")
 	mobi := Mobipocket{}
 	var (
 		err              error
 		firstImageRecord int
 	)
+	if opts != nil && opts.MaxFileSize > 0 {
+		// one byte more to detect the oversized files
+		data, err := io.ReadAll(io.LimitReader(f, opts.MaxFileSize+1))
+		if err != nil {
+			return nil, createMobiFormatError(err)
+		}
+		if !opts.FileSizeAllowed(int64(len(data))) {
+			return nil, createCustomMobiFormatError("Mobipocket file too large")
+		}
+		f = bytes.NewReader(data)
+	}
 	if mobi.db, err = palmdb.ReadDb(f); err != nil {
-		return nil, err
+		return nil, createMobiFormatError(err)
 	}
 
 	if len(mobi.db.Records) == 0 {
@@ -134,7 +242,7 @@ func ReadMobi(f io.Reader) (*Mobipocket, error) {
 	exth, _ := readLongInteger(header, 128)
 
 	if headerRecordSize < exhtStart || (exth&0x40) == 0 {
-		log.Logger.Debug().Msg("No metadata in the file")
+		logger.Debug().Msg("No metadata in the file")
 		return &mobi, nil
 	}
 	//EXTH headers
@@ -143,65 +251,148 @@ func ReadMobi(f io.Reader) (*Mobipocket, error) {
 
 	// "EXTH" text + 4byte of EXTH length
 	pos += 8
+	if headerRecordSize < pos+4 {
+		if opts.IsStrict() {
+			return nil, createCustomMobiFormatError("Truncated EXTH header")
+		}
+		logger.Warn().Msg("Truncated EXTH header")
+		return &mobi, nil
+	}
 	exthCount, pos := readLongInteger(header, pos)
 	for i := 0; i < exthCount; i++ {
 		var exthRecord exthRecord
-		exthRecord, pos, _ = readExthRecord(header, pos)
+		exthRecord, pos, err = readExthRecord(header, pos)
+		if err != nil {
+			if opts.IsStrict() {
+				return nil, createMobiFormatError(err)
+			}
+			logger.Warn().Err(err).Int("record", i).Msg("Broken EXTH record, the rest is skipped")
+			break
+		}
 		mobi.exthRecords = append(mobi.exthRecords, exthRecord)
 	}
 	metadata := mobiMetadata{
-		author:      make([]string, 0),
-		contributor: make([]string, 0),
+		authors:      make([]eBookData.Person, 0),
+		contributors: make([]eBookData.Person, 0),
+		subjects:     make([]string, 0),
+		identifiers:  make([]eBookData.Identifier, 0),
+	}
+	if !mobi.db.ModDate.IsZero() {
+		metadata.modified = eBookData.Date{Time: mobi.db.ModDate.UTC(), Precision: eBookData.DATE_PRECISION_FULL}
 	}
 
 	//parse to metadata
 	title, _, _ := readString(header, titlePos, titleLength, mobi.textEncoding)
 	metadata.title = title
 
-	var coverImage int
+	coverImage := -1
+	thumbnailImage := -1
 	for _, exthRecord := range mobi.exthRecords {
 		// https://github.com/kevinhendricks/KindleUnpack/blob/master/lib/mobi_header.py
 		// https://wiki.mobileread.com/wiki/MOBI
 		switch exthRecord.recordType {
 		case 100:
 			if author, err := readStringFull(exthRecord.content, mobi.textEncoding); err == nil {
-				metadata.author = append(metadata.author, author)
+				metadata.authors = append(metadata.authors, eBookData.Person{Name: author, Roles: []string{eBookData.ROLE_AUTHOR}})
 			}
 		case 101:
 			if publisher, err := readStringFull(exthRecord.content, mobi.textEncoding); err == nil {
 				metadata.publisher = publisher
 			}
+		case 103:
+			if description, err := readStringFull(exthRecord.content, mobi.textEncoding); err == nil {
+				metadata.description = description
+			}
 		case 104:
 			if isbn, err := readStringFull(exthRecord.content, mobi.textEncoding); err == nil {
-				metadata.isbn = isbn
+				metadata.identifiers = eBookData.AppendIdentifier(metadata.identifiers, eBookData.SCHEME_ISBN, isbn)
+			}
+		case 105:
+			if subject, err := readStringFull(exthRecord.content, mobi.textEncoding); err == nil {
+				metadata.subjects = append(metadata.subjects, subject)
 			}
 		case 106:
 			if publishingDate, err := readStringFull(exthRecord.content, mobi.textEncoding); err == nil {
-				metadata.publishingDate = publishingDate
+				if metadata.publishingDate, err = eBookData.ParseDate(publishingDate); err != nil {
+					logger.Debug().Err(err).Msg("Invalid publishing date")
+				}
 			}
 		case 108:
 			if contributor, err := readStringFull(exthRecord.content, mobi.textEncoding); err == nil {
-				metadata.contributor = append(metadata.contributor, contributor)
+				metadata.contributors = append(metadata.contributors, eBookData.Person{Name: contributor, Roles: []string{eBookData.ROLE_CONTRIBUTOR}})
+			}
+		case 109:
+			if rights, err := readStringFull(exthRecord.content, mobi.textEncoding); err == nil {
+				metadata.rights = rights
+			}
+		case 112:
+			// source, calibre stores eg. "calibre:<uuid>" or "urn:isbn:..." here
+			if source, err := readStringFull(exthRecord.content, mobi.textEncoding); err == nil {
+				if scheme, value := eBookData.ParseIdentifier(source); scheme != "" {
+					metadata.identifiers = eBookData.AppendIdentifier(metadata.identifiers, scheme, value)
+				}
+			}
+		case 113, 504:
+			if asin, err := readStringFull(exthRecord.content, mobi.textEncoding); err == nil {
+				metadata.identifiers = eBookData.AppendIdentifier(metadata.identifiers, eBookData.SCHEME_ASIN, asin)
 			}
 		case 121:
 			// kf8start = int(binary.BigEndian.Uint32(exthRecord.content))
 		case 201:
-			coverImage = int(binary.BigEndian.Uint32(exthRecord.content))
+			if len(exthRecord.content) >= 4 && binary.BigEndian.Uint32(exthRecord.content) != NO_RECORD {
+				coverImage = int(binary.BigEndian.Uint32(exthRecord.content))
+			}
+		case 202:
+			if len(exthRecord.content) >= 4 && binary.BigEndian.Uint32(exthRecord.content) != NO_RECORD {
+				thumbnailImage = int(binary.BigEndian.Uint32(exthRecord.content))
+			}
+		case 524:
+			if language, err := readStringFull(exthRecord.content, mobi.textEncoding); err == nil {
+				metadata.language = language
+			}
 		}
 	}
+	metadata.identifiers = opts.NormalizeISBNs(metadata.identifiers)
+	metadata.isbn = eBookData.FindIdentifier(metadata.identifiers, eBookData.SCHEME_ISBN)
 	mobi.metadata = metadata
 
 	// get cover
-	// cover image is the nr.th image from the firstImageRecord
+	// cover image is the nr.th image from the firstImageRecord, the thumbnail is used if there is no cover
+	source := eBookData.COVER_SOURCE_EXTH_COVER
+	switch {
+	case coverImage >= 0:
+	case thumbnailImage >= 0:
+		coverImage = thumbnailImage
+		source = eBookData.COVER_SOURCE_EXTH_THUMBNAIL
+	default:
+		coverImage = 0
+		source = eBookData.COVER_SOURCE_FIRST_IMAGE
+	}
 	coverImage = coverImage + firstImageRecord
-	if len(mobi.db.Records) > coverImage {
-		mobi.cover = mobi.db.Records[coverImage].Data()
+	if !opts.LoadCover() || len(mobi.db.Records) <= coverImage {
+		return &mobi, nil
 	}
+	cover := mobi.db.Records[coverImage].Data()
+	if !opts.CoverSizeAllowed(int64(len(cover))) {
+		if opts.IsStrict() {
+			return nil, createCustomMobiFormatError("Cover image too large")
+		}
+		logger.Warn().Int("size", len(cover)).Msg("Cover image too large, skipped")
+		return &mobi, nil
+	}
+	mobi.cover = eBookData.NewCover(cover, "", source)
+	mobi.cover.Record = coverImage
 	return &mobi, nil
 }
 func readExthRecord(data []byte, pos int) (exthRecord, int, error) {
+	if len(data) < pos+8 {
+		return exthRecord{}, pos, fmt.Errorf("EXTH record header out of range at %d", pos)
+	}
 	rType, pos := readLongInteger(data, pos)
 	rLength, pos := readLongInteger(data, pos)
+	if rLength < 8 || len(data) < rLength+pos-8 {
+		return exthRecord{}, pos, fmt.Errorf("EXTH record length %d out of range at %d", rLength, pos)
+	}
 	rData := data[pos : rLength+pos-8]
 	ret := exthRecord{recordType: rType, length: rLength, content: rData}
 
@@ -210,6 +401,6 @@ func readExthRecord(data []byte, pos int) (exthRecord, int, error) {
 func (mobi Mobipocket) Metadata() eBookData.Metadata {
 	return mobi.metadata
 }
-func (mobi Mobipocket) Cover() []byte {
+func (mobi Mobipocket) Cover() *eBookData.Cover {
 	return mobi.cover
 }
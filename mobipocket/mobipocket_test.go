@@ -0,0 +1,213 @@
+package mobipocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
+)
+
+// createMobi builds a PalmDB file with a single header record: PalmDOC header, MOBI header, EXTH records and the title
+func createMobi(t *testing.T, title string, exth map[int]string) []byte {
+	t.Helper()
+	return createMobiWithImages(t, title, exth, nil)
+}
+
+// createMobiWithImages builds a PalmDB file with the header record and the images as the following records
+func createMobiWithImages(t *testing.T, title string, exth map[int]string, images [][]byte) []byte {
+	t.Helper()
+	const mobiHeaderLength = 232
+	exthData := new(bytes.Buffer)
+	types := make([]int, 0, len(exth))
+	for recordType := range exth {
+		types = append(types, recordType)
+	}
+	slices.Sort(types)
+	for _, recordType := range types {
+		binary.Write(exthData, binary.BigEndian, uint32(recordType))
+		binary.Write(exthData, binary.BigEndian, uint32(len(exth[recordType])+8))
+		exthData.WriteString(exth[recordType])
+	}
+
+	header := make([]byte, 16+mobiHeaderLength)
+	copy(header[16:], "MOBI")
+	binary.BigEndian.PutUint32(header[20:], mobiHeaderLength)
+	binary.BigEndian.PutUint32(header[24:], 2)
+	binary.BigEndian.PutUint32(header[28:], 65001)
+	titlePos := len(header) + 12 + exthData.Len()
+	binary.BigEndian.PutUint32(header[84:], uint32(titlePos))
+	binary.BigEndian.PutUint32(header[88:], uint32(len(title)))
+	if len(images) == 0 {
+		binary.BigEndian.PutUint32(header[108:], 0xFFFFFFFF)
+	} else {
+		binary.BigEndian.PutUint32(header[108:], 1)
+	}
+	binary.BigEndian.PutUint32(header[128:], 0x40)
+	record := bytes.NewBuffer(header)
+	record.WriteString("EXTH")
+	binary.Write(record, binary.BigEndian, uint32(12+exthData.Len()))
+	binary.Write(record, binary.BigEndian, uint32(len(exth)))
+	record.Write(exthData.Bytes())
+	record.WriteString(title)
+
+	records := append([][]byte{record.Bytes()}, images...)
+	db := make([]byte, 78)
+	copy(db, "Test")
+	copy(db[60:], "BOOKMOBI")
+	binary.BigEndian.PutUint16(db[76:], uint16(len(records)))
+	offset := 78 + 8*len(records) + 2
+	for _, data := range records {
+		entry := make([]byte, 8)
+		binary.BigEndian.PutUint32(entry, uint32(offset))
+		db = append(db, entry...)
+		offset += len(data)
+	}
+	db = append(db, 0, 0)
+	for _, data := range records {
+		db = append(db, data...)
+	}
+	return db
+}
+
+func TestReadMobiPersons(t *testing.T) {
+	data := createMobi(t, "Persons", map[int]string{100: "Jane Author", 108: "John Helper"})
+	mobi, err := ReadMobi(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadMobi failed: %v", err)
+	}
+	metadata := mobi.Metadata()
+	if metadata.Title() != "Persons" {
+		t.Errorf("Unexpected title %q", metadata.Title())
+	}
+	authors := metadata.Authors()
+	if len(authors) != 1 || authors[0].Name != "Jane Author" || !authors[0].IsAuthor() {
+		t.Errorf("Unexpected authors %+v", authors)
+	}
+	contributors := metadata.Contributors()
+	if len(contributors) != 1 || contributors[0].Name != "John Helper" {
+		t.Fatalf("Unexpected contributors %+v", contributors)
+	}
+	if contributors[0].IsAuthor() || !contributors[0].HasRole(eBookData.ROLE_CONTRIBUTOR) {
+		t.Errorf("The EXTH 108 contributor should not be an author %+v", contributors[0])
+	}
+}
+
+func TestReadMobiExth(t *testing.T) {
+	tests := []struct {
+		name  string
+		exth  map[int]string
+		check func(t *testing.T, metadata eBookData.Metadata)
+	}{
+		{"103 description", map[int]string{103: "A short description"}, func(t *testing.T, metadata eBookData.Metadata) {
+			if metadata.Description() != "A short description" {
+				t.Errorf("Unexpected description %q", metadata.Description())
+			}
+		}},
+		{"105 subjects", map[int]string{105: "Fantasy"}, func(t *testing.T, metadata eBookData.Metadata) {
+			if subjects := metadata.Subjects(); len(subjects) != 1 || subjects[0] != "Fantasy" {
+				t.Errorf("Unexpected subjects %v", subjects)
+			}
+		}},
+		{"106 publishing date", map[int]string{106: "2020-05-17"}, func(t *testing.T, metadata eBookData.Metadata) {
+			date := metadata.PublishedDate()
+			if date.Precision != eBookData.DATE_PRECISION_DAY || date.String() != "2020-05-17" {
+				t.Errorf("Unexpected publishing date %+v", date)
+			}
+		}},
+		{"106 invalid date", map[int]string{106: "someday"}, func(t *testing.T, metadata eBookData.Metadata) {
+			if !metadata.PublishedDate().IsZero() {
+				t.Errorf("The invalid date should be empty %+v", metadata.PublishedDate())
+			}
+		}},
+		{"109 rights", map[int]string{109: "All rights reserved"}, func(t *testing.T, metadata eBookData.Metadata) {
+			if metadata.Rights() != "All rights reserved" {
+				t.Errorf("Unexpected rights %q", metadata.Rights())
+			}
+		}},
+		{"113 asin", map[int]string{113: "B000000001"}, func(t *testing.T, metadata eBookData.Metadata) {
+			if asin := eBookData.FindIdentifier(metadata.Identifiers(), eBookData.SCHEME_ASIN); asin != "B000000001" {
+				t.Errorf("Unexpected ASIN %q", asin)
+			}
+		}},
+		{"504 asin", map[int]string{504: "B000000002"}, func(t *testing.T, metadata eBookData.Metadata) {
+			if asin := eBookData.FindIdentifier(metadata.Identifiers(), eBookData.SCHEME_ASIN); asin != "B000000002" {
+				t.Errorf("Unexpected ASIN %q", asin)
+			}
+		}},
+		{"113 and 504", map[int]string{113: "B000000001", 504: "B000000002"}, func(t *testing.T, metadata eBookData.Metadata) {
+			expected := []eBookData.Identifier{{Scheme: eBookData.SCHEME_ASIN, Value: "B000000001"}, {Scheme: eBookData.SCHEME_ASIN, Value: "B000000002"}}
+			if identifiers := metadata.Identifiers(); !slices.Equal(identifiers, expected) {
+				t.Errorf("Expected %v, got %v", expected, identifiers)
+			}
+		}},
+		{"same asin", map[int]string{113: "B000000001", 504: "B000000001"}, func(t *testing.T, metadata eBookData.Metadata) {
+			if identifiers := metadata.Identifiers(); len(identifiers) != 1 {
+				t.Errorf("The same ASIN should be listed once %v", identifiers)
+			}
+		}},
+		{"524 language", map[int]string{524: "hu"}, func(t *testing.T, metadata eBookData.Metadata) {
+			if metadata.Language() != "hu" {
+				t.Errorf("Unexpected language %q", metadata.Language())
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mobi, err := ReadMobi(bytes.NewReader(createMobi(t, "Exth", tt.exth)), nil)
+			if err != nil {
+				t.Fatalf("ReadMobi failed: %v", err)
+			}
+			tt.check(t, mobi.Metadata())
+		})
+	}
+}
+
+func TestReadMobiTruncatedExth(t *testing.T) {
+	data := createMobi(t, "T", map[int]string{100: "Jane Author", 524: "en"})
+	// one more record than the header contains, it runs into the title
+	exth := bytes.Index(data, []byte("EXTH"))
+	binary.BigEndian.PutUint32(data[exth+8:], 3)
+
+	mobi, err := ReadMobi(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("ReadMobi failed: %v", err)
+	}
+	metadata := mobi.Metadata()
+	if authors := metadata.Author(); len(authors) != 1 || authors[0] != "Jane Author" || metadata.Language() != "en" {
+		t.Errorf("The records before the broken one should be read: %v %q", authors, metadata.Language())
+	}
+	if _, err := ReadMobi(bytes.NewReader(data), &eBookData.ParseOptions{Strict: true}); err == nil {
+		t.Errorf("Expected error for the truncated EXTH in strict mode")
+	}
+}
+
+func TestReadMobiUnsetCover(t *testing.T) {
+	unset := string([]byte{0xFF, 0xFF, 0xFF, 0xFF})
+	images := [][]byte{[]byte("first image"), []byte("thumbnail")}
+	tests := []struct {
+		name   string
+		exth   map[int]string
+		data   string
+		source eBookData.CoverSource
+	}{
+		{"thumbnail", map[int]string{201: unset, 202: string([]byte{0, 0, 0, 1})}, "thumbnail", eBookData.COVER_SOURCE_EXTH_THUMBNAIL},
+		{"first image", map[int]string{201: unset, 202: unset}, "first image", eBookData.COVER_SOURCE_FIRST_IMAGE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mobi, err := ReadMobi(bytes.NewReader(createMobiWithImages(t, "Cover", tt.exth, images)), nil)
+			if err != nil {
+				t.Fatalf("ReadMobi failed: %v", err)
+			}
+			cover := mobi.Cover()
+			if cover == nil {
+				t.Fatalf("No cover")
+			}
+			if string(cover.Data) != tt.data || cover.Source != tt.source {
+				t.Errorf("Expected %q from %s, got %q from %s", tt.data, tt.source, cover.Data, cover.Source)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package ebook
+
+import (
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ignisVeneficus/ebook/eBookData"
+	"github.com/ignisVeneficus/ebook/epub"
+	"github.com/ignisVeneficus/ebook/mobipocket"
+)
+
+// Format describes an ebook format that Parse can dispatch to.
+// Open receives the options given to Parse, it can be nil.
+// Detect is checked first on the content, Extensions (with the leading dot, eg. ".epub")
+// are only used when no registered format recognizes the content.
+// OpenFile is optional, Parse uses it instead of Open for the formats that read the file after opening it:
+// the returned book owns the file and closes it with its Close method.
+type Format struct {
+	Name       string
+	Extensions []string
+	Detect     func(r io.ReaderAt, size int64) bool
+	Open       func(r io.ReaderAt, size int64, opts *eBookData.ParseOptions) (eBookData.Book, error)
+	OpenFile   func(path string, opts *eBookData.ParseOptions) (eBookData.Book, error)
+}
+
+var (
+	formatsLock sync.RWMutex
+	formats     = make([]Format, 0)
+)
+
+// Register adds a format to the registry. A format registered with an already used name replaces the old one.
+func Register(format Format) {
+	formatsLock.Lock()
+	defer formatsLock.Unlock()
+	idx := slices.IndexFunc(formats, func(f Format) bool { return f.Name == format.Name })
+	if idx >= 0 {
+		formats[idx] = format
+		return
+	}
+	formats = append(formats, format)
+}
+
+// Formats returns the registered formats in registration order.
+func Formats() []Format {
+	formatsLock.RLock()
+	defer formatsLock.RUnlock()
+	return slices.Clone(formats)
+}
+
+func detectFormat(r io.ReaderAt, size int64, name string) (Format, bool) {
+	registered := Formats()
+	for _, format := range registered {
+		if format.Detect != nil && format.Detect(r, size) {
+			return format, true
+		}
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return Format{}, false
+	}
+	for _, format := range registered {
+		for _, e := range format.Extensions {
+			if strings.ToLower(e) == ext {
+				return format, true
+			}
+		}
+	}
+	return Format{}, false
+}
+
+func openEpub(r io.ReaderAt, size int64, opts *eBookData.ParseOptions) (eBookData.Book, error) {
+	book, err := epub.ReadEpubAt(r, size, opts)
+	if err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+func openEpubFile(path string, opts *eBookData.ParseOptions) (eBookData.Book, error) {
+	book, err := epub.OpenEpub(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+func openMobi(r io.ReaderAt, size int64, opts *eBookData.ParseOptions) (eBookData.Book, error) {
+	book, err := mobipocket.ReadMobi(io.NewSectionReader(r, 0, size), opts)
+	if err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+func init() {
+	Register(Format{
+		Name:       "epub",
+		Extensions: []string{".epub"},
+		Detect:     isEpub,
+		Open:       openEpub,
+		OpenFile:   openEpubFile,
+	})
+	Register(Format{
+		Name:       "mobipocket",
+		Extensions: []string{".mobi", ".azw", ".prc"},
+		Detect: func(r io.ReaderAt, size int64) bool {
+			return isMobi(r)
+		},
+		Open: openMobi,
+	})
+}